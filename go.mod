@@ -4,10 +4,12 @@ go 1.25.0
 
 require (
 	github.com/desertbit/columnize v2.1.0+incompatible
+	github.com/desertbit/go-shlex v0.1.1
 	github.com/desertbit/grumble v1.3.1
+	github.com/desertbit/readline v1.5.1
 	github.com/fatih/color v1.19.0
 	gopkg.in/AlecAivazis/survey.v1 v1.8.8
-	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,8 +17,6 @@ require (
 	github.com/chzyer/logex v1.2.0 // indirect
 	github.com/chzyer/test v0.0.0-20210722231415-061457976a23 // indirect
 	github.com/desertbit/closer/v4 v4.0.2 // indirect
-	github.com/desertbit/go-shlex v0.1.1 // indirect
-	github.com/desertbit/readline v1.5.1 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
@@ -32,6 +32,7 @@ import (
 	"github.com/desertbit/grml/internal/manifest"
 	"github.com/desertbit/grml/internal/options"
 	"github.com/desertbit/grumble"
+	"github.com/desertbit/readline"
 	"github.com/fatih/color"
 )
 
@@ -46,11 +47,22 @@ type app struct {
 	verbose      bool
 	rootPath     string
 	manifestPath string
+	overlayPaths []string // merged on top of the manifest in order
+	cwd          string   // directory grml was invoked from
 
 	env      map[string]string
 	manifest *manifest.Manifest
 	options  map[string]*options.Options // keyed by scope path; "" is root scope
+	profile  string                      // name of the active option profile
 	commands cmd.Commands
+	hidden   map[*grumble.Command]bool               // registered hidden commands
+	subs     map[*grumble.Command][]*grumble.Command // sub commands, grumble doesn't expose them
+	builtins []string                                // names and aliases of the top-level builtins
+	history  []historyEntry                          // runs of the shell
+
+	// completer of the shell, without the hidden commands.
+	completer readline.AutoCompleter
+	rl        *readline.Instance
 }
 
 // Run the application.
@@ -65,22 +77,39 @@ func Run() {
 			HelpHeadlineUnderline: true,
 			HelpSubCommands:       true,
 
-			Flags: func(f *grumble.Flags) {
-				f.String("d", "directory", ".", "set the root directory path")
-				f.String("f", "file", defaultManifestFilename, "set an alternative grml file (relative to the root directory)")
-				f.Bool("v", "verbose", false, "enable verbose execution mode")
-			},
+			Flags: registerFlags,
 		}),
 
 		fgColor: color.New(color.FgYellow),
 		env:     make(map[string]string),
+		hidden:  make(map[*grumble.Command]bool),
+		subs:    make(map[*grumble.Command][]*grumble.Command),
 	}
 
+	// Run the app like grumble.Main, but keep the readline instance to
+	// extend its completion.
+	rl, err := readline.NewEx(&readline.Config{})
+	if err != nil {
+		fatal(err)
+	}
+	a.rl = rl
+
 	a.SetPrintASCIILogo(func(gapp *grumble.App) {
 		a.printGRML()
 	})
+	a.SetPrintHelp(a.printHelp)
+	a.SetPrintCommandHelp(a.printCommandHelp)
 
 	a.OnShell(func(gapp *grumble.App) error {
+		// Hide the hidden commands from tab completion.
+		rl.Config.AutoComplete = a.completer
+		rl.Config.FuncFilterInputRune = a.filterInputRune
+
+		// Continue the run history of the project.
+		if err := a.readHistory(); err != nil {
+			a.Printf("warning: history: %v\n", err)
+		}
+
 		// Ignore interrupt signals, because grumble will handle the interrupts anyway.
 		// and the interrupt signals will be passed through automatically to all
 		// client processes. They will exit, but the shell will pop up and stay alive.
@@ -95,6 +124,8 @@ func Run() {
 	})
 
 	a.OnInit(func(gapp *grumble.App, flags grumble.FlagMap) (err error) {
+		a.completer = &visibleCompleter{a: a, next: rl.Config.AutoComplete}
+
 		// Initialize global flag values.
 		a.verbose = flags.Bool("verbose")
 		a.rootPath = flags.String("directory")
@@ -105,21 +136,184 @@ func Run() {
 		if err != nil {
 			return err
 		}
+		a.cwd, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		// The first file is the grml file, the others are overlays. grumble
+		// only keeps the last occurrence of a repeated flag, so collect them
+		// from the raw arguments.
+		files := flagValues(os.Args[1:], "f", "file")
+		if len(files) == 0 {
+			files = []string{defaultManifestFilename}
+		}
 
-		// Resolve the manifest file path. Absolute paths are taken as-is;
-		// relative paths are resolved against the root directory.
-		a.manifestPath = filepath.Join(a.rootPath, flags.String("file"))
+		// Without an explicit root directory, use the nearest parent
+		// directory containing the grml file.
+		if len(flagValues(os.Args[1:], "d", "directory")) == 0 && !filepath.IsAbs(files[0]) {
+			if root, ok := findRoot(a.rootPath, files[0]); ok {
+				a.rootPath = root
+			}
+		}
 
-		// Load the manifest.
-		return a.load()
+		// Resolve the file paths. Absolute paths are taken as-is; relative
+		// paths are resolved against the root directory.
+		for i, f := range files {
+			if !filepath.IsAbs(f) {
+				files[i] = filepath.Join(a.rootPath, f)
+			}
+		}
+		a.manifestPath = files[0]
+		a.overlayPaths = overlayPaths(files)
+
+		// Load the manifest. The check command reports the problems of
+		// a broken manifest itself, migrate upgrades incompatible ones and
+		// the schema doesn't depend on it.
+		err = a.load()
+		if err != nil {
+			switch commandName(os.Args[1:]) {
+			case "check", "lint", "migrate", "schema", "completion", "__complete":
+				return nil
+			}
+			return err
+		}
+
+		// Apply the option profile first, so single options override it.
+		if p := flags.String("profile"); p != "" {
+			err = a.applyProfile(p)
+			if err != nil {
+				return err
+			}
+			a.profile = p
+		}
+
+		// Apply option values passed on the command line. grumble only
+		// keeps the last occurrence of a repeated flag, so collect them
+		// from the raw arguments. The constraints are checked once the
+		// profile and all values are applied.
+		return a.setOptionFlags(flagValues(os.Args[1:], "o", "option"))
 	})
 
-	grumble.Main(a.App)
+	err = a.RunWithReadline(rl)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// fatal prints the error like grumble.Main and exits with the exit code
+// of the failed shell command, if any.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(exitStatus(err))
+}
+
+// globalFlag is a flag of the grml command line.
+type globalFlag struct {
+	short, long string
+	def         interface{} // The default value, its type sets the flag's.
+	help        string
+}
+
+// globalFlags lists the flags of the grml command line.
+var globalFlags = []globalFlag{
+	{"d", "directory", ".", "set the root directory path instead of the nearest parent with a grml file"},
+	{"f", "file", defaultManifestFilename, "set an alternative grml file (relative to the root directory), repeat to merge overlays"},
+	{"v", "verbose", false, "enable verbose execution mode"},
+	{"o", "option", []string(nil), "set an option value ([command.]name=value), may be repeated"},
+	{"", "profile", "", "apply a named option profile"},
+}
+
+// builtinFlags lists the flags grumble registers itself. They are only
+// needed to print the help.
+var builtinFlags = []globalFlag{
+	{"h", "help", false, "display help"},
+	{"", "nocolor", false, "disable color output"},
+}
+
+// registerFlags registers the global flags.
+func registerFlags(f *grumble.Flags) {
+	for _, gf := range globalFlags {
+		switch def := gf.def.(type) {
+		case string:
+			f.String(gf.short, gf.long, def, gf.help)
+		case bool:
+			f.Bool(gf.short, gf.long, def, gf.help)
+		case []string:
+			f.StringList(gf.short, gf.long, def, gf.help)
+		}
+	}
+}
+
+// valueFlags lists the global flags that consume a value argument.
+var valueFlags = []string{"-d", "--directory", "-f", "--file", "-o", "--option", "--profile"}
+
+// splitArgs splits the command line args into the global flags, each as
+// name and value, and the command with its args. The flag parsing matches
+// grumble's.
+func splitArgs(args []string) (flags [][2]string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flags, args[i+1:]
+		} else if !strings.HasPrefix(arg, "-") {
+			return flags, args[i:]
+		}
+
+		name, value, inline := strings.Cut(arg, "=")
+		if !inline {
+			for _, f := range valueFlags {
+				if f == name && i+1 < len(args) {
+					i++
+					value = args[i]
+					break
+				}
+			}
+		}
+		flags = append(flags, [2]string{name, value})
+	}
+	return
+}
+
+// flagValues returns every value passed for the global flag short/long
+// in order.
+func flagValues(args []string, short, long string) (values []string) {
+	flags, _ := splitArgs(args)
+	for _, f := range flags {
+		if f[0] == "-"+short || f[0] == "--"+long {
+			values = append(values, f[1])
+		}
+	}
+	return
+}
+
+// commandName returns the name of the command to run or an empty string
+// for shell sessions.
+func commandName(args []string) string {
+	_, rest := splitArgs(args)
+	if len(rest) == 0 {
+		return ""
+	}
+	return rest[0]
+}
+
+// overlayPaths returns the overlays of the grml file, the first of the
+// files: the other files and its local override file, if present, which
+// is merged last.
+func overlayPaths(files []string) []string {
+	overlays := append([]string{}, files[1:]...)
+	if local := manifest.LocalPath(files[0]); fileExists(local) {
+		overlays = append(overlays, local)
+	}
+	return overlays
 }
 
 func (a *app) load() (err error) {
 	// Remove previous commands first.
 	a.Commands().RemoveAll()
+	a.builtins = nil
+	a.hidden = make(map[*grumble.Command]bool)
+	a.subs = make(map[*grumble.Command][]*grumble.Command)
 
 	// Add built-int commands.
 	a.AddCommand(&grumble.Command{
@@ -146,12 +340,59 @@ func (a *app) load() (err error) {
 			return
 		},
 	})
+	a.AddCommand(&grumble.Command{
+		Name:    "check",
+		Aliases: []string{"lint"},
+		Help:    "check the grml file and its includes for problems",
+		Run: func(c *grumble.Context) error {
+			return a.check()
+		},
+	})
+	a.AddCommand(&grumble.Command{
+		Name: "migrate",
+		Help: "upgrade the grml file to the current version",
+		Run: func(c *grumble.Context) error {
+			return a.migrate()
+		},
+	})
+	a.AddCommand(&grumble.Command{
+		Name:     "schema",
+		Help:     "print the JSON Schema of the grml file",
+		LongHelp: "Prints the JSON Schema of the grml file or, with 'include', of an included subgrml file.",
+		Args: func(a *grumble.Args) {
+			a.String("kind", "'manifest' or 'include'", grumble.Default("manifest"))
+		},
+		Completer: func(prefix string, args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			var words []string
+			for _, kind := range []string{"manifest", "include"} {
+				if strings.HasPrefix(kind, prefix) {
+					words = append(words, kind)
+				}
+			}
+			return words
+		},
+		Run: func(c *grumble.Context) error {
+			return a.schema(c.Args.String("kind"))
+		},
+	})
+	a.attachCompletion(a.AddCommand)
 
 	// Read the grml file.
-	a.manifest, err = manifest.Parse(a.manifestPath)
+	a.manifest, err = manifest.Parse(a.manifestPath, a.overlayPaths...)
 	if err != nil {
 		return fmt.Errorf("grml file: %v", err)
 	}
+	switch commandName(os.Args[1:]) {
+	case "check", "lint", "migrate", "__complete":
+		// Reported or resolved by the command itself, or irrelevant.
+	default:
+		for _, d := range a.manifest.Deprecations {
+			a.Printf("warning: %s\n", d)
+		}
+	}
 
 	// Set the updated prompt.
 	if a.manifest.Project != "" {
@@ -163,37 +404,57 @@ func (a *app) load() (err error) {
 	if err != nil {
 		return fmt.Errorf("failed to parse options: %v", err)
 	}
+	if err = a.checkProfiles(); err != nil {
+		return
+	}
 	// Attach the root scope's options UI at the top level.
 	if _, ok := a.options[""]; ok {
 		a.attachOptions(a.AddCommand, "")
 	}
+	if len(a.manifest.Profiles) > 0 {
+		a.attachProfile(a.AddCommand)
+	}
 
 	// Prepare the environment.
-	// Inherit the current process environment.
-	for _, v := range os.Environ() {
-		p := strings.Index(v, "=")
-		if p > 0 {
-			a.env[v[0:p]] = v[p+1:]
-		}
-	}
+	a.env = a.baseEnv()
 	a.env["PROJECT"] = a.manifest.Project
-	a.env["ROOT"] = a.rootPath
-	a.env["NUMCPU"] = strconv.Itoa(runtime.NumCPU())
 	a.env = a.manifest.EvalEnv(a.env) // Add values from manifest.
 
-	// Group all commands to the builtin group (help message).
-	cmds := a.Commands().All()
-	for _, c := range cmds {
-		c.HelpGroup = "Builtins:"
-	}
-
 	// Prepare the commands.
 	a.commands, err = cmd.ParseManifest(a.manifest)
 	if err != nil {
 		return
 	}
 
-	// Register the commands to grumble.
+	// The foreach builtin is only useful with includes.
+	hasIncludes := false
+	a.commands.Walk(func(c *cmd.Command) {
+		hasIncludes = hasIncludes || c.IsInclude()
+	})
+	if hasIncludes {
+		a.attachForeach(a.AddCommand)
+	}
+	if len(a.functionScopes()) > 0 {
+		a.attachFunctions(a.AddCommand)
+	}
+	a.attachDocs(a.AddCommand)
+	a.attachList(a.AddCommand)
+	a.attachPick(a.AddCommand)
+	if a.IsShell() {
+		a.attachHistory(a.AddCommand)
+	}
+
+	// Group all builtins to the builtin group (help message).
+	for _, c := range a.Commands().All() {
+		c.HelpGroup = "Builtins:"
+	}
+
+	// Register the commands to grumble. grumble finds the first command of
+	// a name, so a command colliding with a builtin would be unreachable.
+	a.builtins = a.builtinNames()
+	if err = a.checkBuiltinNames(a.commands, a.builtins); err != nil {
+		return
+	}
 	a.registerCommands(a.AddCommand, a.commands)
 
 	return
@@ -219,24 +480,39 @@ func (a *app) reload() (err error) {
 			o.Restore(old)
 		}
 	}
+	if err = a.checkOptions(); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	// Drop the active profile if it was removed.
+	if _, ok := a.manifest.Profiles[a.profile]; !ok {
+		a.profile = ""
+	}
 	return
 }
 
+// registerCommands registers the grumble commands of cs. Hidden commands
+// are registered too, but left out of the help and the completion.
 func (a *app) registerCommands(parentAddCmd func(cmd *grumble.Command), cs cmd.Commands) {
 	for _, c := range cs {
 		var (
 			localCmd = c // Catch the variable locally for run.
 		)
 		gc := &grumble.Command{
-			Name:    c.Name(),
-			Aliases: c.Alias(),
-			Help:    a.evalVar(a.cmdEnv(c), c.Help()), // Help messages may contain scoped variables.
+			Name:      c.Name(),
+			Aliases:   c.Alias(),
+			Help:      a.evalVar(a.cmdEnv(c), c.Help()), // Help messages may contain scoped variables.
+			LongHelp:  a.longHelp(c),
+			HelpGroup: helpGroup(c.Group()),
 			Args: func(ga *grumble.Args) {
 				for _, arg := range localCmd.Args() {
-					ga.String(arg, "_")
+					ga.String(arg, "exported as $"+arg)
 				}
 			},
 			Run: func(c *grumble.Context) error {
+				if localCmd.Internal() {
+					return fmt.Errorf("command '%s' is internal and only runs as a dependency", localCmd.Path())
+				}
 				var args map[string]string
 				if localCmd.HasArgs() {
 					args = make(map[string]string)
@@ -254,13 +530,8 @@ func (a *app) registerCommands(parentAddCmd func(cmd *grumble.Command), cs cmd.C
 		// back to its default sub-command-name suggestion.
 		if localCmd.HasArgs() {
 			// Pre-compute the completion base so each tab keystroke doesn't
-			// re-walk the env scope chain. For subgrml commands this is
-			// ${LOCAL_ROOT}; for root commands it's the project root —
-			// matching the runtime cwd.
-			completeBase := a.rootPath
-			if lr := a.cmdEnv(c)["LOCAL_ROOT"]; lr != "" {
-				completeBase = lr
-			}
+			// re-walk the env scope chain.
+			completeBase := a.completeBase(c)
 			gc.Completer = func(prefix string, args []string) []string {
 				if len(args) >= len(localCmd.Args()) {
 					return nil
@@ -290,20 +561,38 @@ func (a *app) registerCommands(parentAddCmd func(cmd *grumble.Command), cs cmd.C
 
 		// Add sub commands to this grumble command.
 		if c.HasSubCommands() {
-			a.registerCommands(gc.AddCommand, c.SubCommands())
+			a.registerCommands(a.addSubCommand(gc), c.SubCommands())
 		}
 
 		// If this command declared its own options scope, attach the options
 		// UI under it (e.g. 'labrat options check', 'labrat options set foo').
 		if _, ok := a.options[c.Path()]; ok {
-			a.attachOptions(gc.AddCommand, c.Path())
+			a.attachOptions(a.addSubCommand(gc), c.Path())
 		}
 
 		// Add this grumble command to the parent.
+		if c.Hidden() {
+			a.hidden[gc] = true
+		}
 		parentAddCmd(gc)
 	}
 }
 
+// completeBase returns the directory the args of c are completed in. For
+// subgrml commands this is ${LOCAL_ROOT}; for root commands it's the
+// project root — matching the runtime cwd.
+func (a *app) completeBase(c *cmd.Command) string {
+	// If grml was started in a subdirectory of the project, args are most
+	// likely relative to it (see GRML_CWD).
+	if a.inSubdir() {
+		return a.cwd
+	}
+	if lr := a.cmdEnv(c)["LOCAL_ROOT"]; lr != "" {
+		return lr
+	}
+	return a.rootPath
+}
+
 // completePath returns filesystem completion candidates for prefix, resolved
 // against base when prefix is relative. Directories get a trailing '/'. Hidden
 // entries are only listed when the user explicitly typed a leading dot.
@@ -346,6 +635,22 @@ func completePath(prefix, base string) []string {
 	return matches
 }
 
+// baseEnv returns the inherited process environment together with the
+// implicit variables that don't depend on the manifest.
+func (a *app) baseEnv() map[string]string {
+	env := make(map[string]string)
+	for _, v := range os.Environ() {
+		p := strings.Index(v, "=")
+		if p > 0 {
+			env[v[0:p]] = v[p+1:]
+		}
+	}
+	env["ROOT"] = a.rootPath
+	env["GRML_CWD"] = a.cwd
+	env["NUMCPU"] = strconv.Itoa(runtime.NumCPU())
+	return env
+}
+
 // evalVar interpolates ${VAR} references in str using the provided env map.
 // Options are not included; pass them via the env at the call site if needed.
 func (a *app) evalVar(env map[string]string, str string) string {
@@ -369,32 +674,55 @@ func (a *app) cmdEnv(c *cmd.Command) map[string]string {
 // with c's scope chain layered on top of the root env.
 func (a *app) execEnv(c *cmd.Command) (env []string) {
 	// Environment variables (root + scoped).
-	for k, v := range a.cmdEnv(c) {
+	cenv := a.cmdEnv(c)
+	for k, v := range cenv {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
 	// Layer options across applicable scopes. Walk outermost (root) to
 	// innermost (c's path); inner scopes shadow outer for same-named options.
-	bools := make(map[string]bool)
-	choices := make(map[string]string)
+	for k, v := range a.optionsEnv(c.Path()) {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	// Variables mapped to the active option values, layered the same way.
+	// Added last, so they override env entries of the same name.
+	mapped := make(map[string]string)
 	for _, sp := range a.activeOptionScopes(c.Path()) {
-		opts := a.options[sp]
-		for k, o := range opts.Bools {
-			bools[k] = o.Value
-		}
-		for k, o := range opts.Choices {
-			choices[k] = o.Active
+		for k, v := range a.options[sp].MappedEnv() {
+			mapped[k] = a.evalVar(cenv, v)
 		}
 	}
-	for k, v := range bools {
-		env = append(env, fmt.Sprintf("%s=%v", k, v))
-	}
-	for k, v := range choices {
+	for k, v := range mapped {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 	return
 }
 
+// optionsEnv returns the option values visible to the scope at path,
+// keyed by option name.
+func (a *app) optionsEnv(path string) map[string]string {
+	env := make(map[string]string)
+	for _, sp := range a.activeOptionScopes(path) {
+		for k, v := range a.options[sp].Env() {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// optionsActive returns whether the options visible to the command at
+// path are active, layered like optionsEnv.
+func (a *app) optionsActive(path string) map[string]bool {
+	active := make(map[string]bool)
+	for _, sp := range a.activeOptionScopes(path) {
+		for k, v := range a.options[sp].ActiveEnv() {
+			active[k] = v
+		}
+	}
+	return active
+}
+
 // activeOptionScopes returns the scope paths that contribute options to a
 // command at cmdPath, ordered outermost (root) first to innermost (cmdPath).
 // Only scopes that actually have an Options entry are included.
@@ -19,10 +19,57 @@
 package app
 
 import (
+	"bytes"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/desertbit/grumble"
+	"github.com/fatih/color"
 )
 
+// newTestApp loads grml.yaml of the files written below a temporary root
+// directory.
+func newTestApp(t *testing.T, files map[string]string) *app {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	color.NoColor = true
+	a := &app{
+		App:          grumble.New(&grumble.Config{Name: "grml"}),
+		fgColor:      color.New(color.FgYellow),
+		rootPath:     dir,
+		manifestPath: filepath.Join(dir, defaultManifestFilename),
+		cwd:          dir,
+		env:          make(map[string]string),
+		hidden:       make(map[*grumble.Command]bool),
+		subs:         make(map[*grumble.Command][]*grumble.Command),
+	}
+	if err := a.load(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// newTestContext returns an exec context writing the output to out.
+func newTestContext(out *bytes.Buffer) *execContext {
+	ctx := newExecContext()
+	ctx.stdin = nil
+	ctx.stdout = out
+	ctx.stderr = out
+	return ctx
+}
+
 // TestCompletePath drives the path completer against the in-tree sample
 // directory, mirroring what tab completion produces when typing args at
 // the grml prompt.
@@ -82,3 +129,115 @@ func TestCompletePath(t *testing.T) {
 		})
 	}
 }
+
+func TestFindRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"repo/.git", "repo/svc/pkg", "repo/other"} {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"outer.yaml", "repo/grml.yaml", "repo/svc/grml.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, p), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		file  string
+		start string
+		want  string
+	}{
+		{"grml.yaml", "repo/svc/pkg", "repo/svc"},
+		{"grml.yaml", "repo/other", "repo"},
+		{"grml.yaml", "repo", "repo"},
+		// The search must not leave the git repository.
+		{"outer.yaml", "repo/svc/pkg", ""},
+	}
+	for _, c := range cases {
+		root, ok := findRoot(filepath.Join(dir, c.start), c.file)
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s from %s: got %s, want not found", c.file, c.start, root)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, c.want); !ok || root != want {
+			t.Errorf("%s from %s: got %s, want %s", c.file, c.start, root, want)
+		}
+	}
+}
+
+func TestFuzzyFilter(t *testing.T) {
+	options := []string{"release.publish", "release.tag", "build", "go.get", "go.tidy"}
+
+	cases := []struct {
+		filter string
+		want   []string
+	}{
+		{"", options},
+		{"gt", []string{"go.tidy", "go.get"}},
+		{"REL tag", []string{"release.tag"}},
+		{"tidy", []string{"go.tidy"}},
+		{"xyz", []string{}},
+	}
+	for _, tc := range cases {
+		got := fuzzyFilter(tc.filter, options)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("filter=%q: got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestOverlayPaths(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"grml.yaml", "grml.ci.yaml", "other.yaml", "other.local.yaml"} {
+		if err := os.WriteFile(path(name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		files, want []string
+	}{
+		{[]string{path("grml.yaml")}, []string{}},
+		{[]string{path("grml.yaml"), path("grml.ci.yaml")}, []string{path("grml.ci.yaml")}},
+		// The local file of the grml file is merged last.
+		{[]string{path("other.yaml"), path("grml.ci.yaml")}, []string{path("grml.ci.yaml"), path("other.local.yaml")}},
+	}
+	for _, c := range cases {
+		got := overlayPaths(c.files)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%v: got %v, want %v", c.files, got, c.want)
+		}
+	}
+
+	if err := os.WriteFile(path("grml.local.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := overlayPaths([]string{path("grml.yaml")}); len(got) != 1 || got[0] != path("grml.local.yaml") {
+		t.Errorf("got %v, want grml.local.yaml", got)
+	}
+}
+
+func TestBuiltinCollisions(t *testing.T) {
+	a := newTestApp(t, map[string]string{"grml.yaml": "version: 3\nproject: p\n"})
+
+	cases := map[string]string{
+		"commands:\n    list:\n        help: list\n":                    "grml.yaml:4:5: command 'list' collides with builtin 'list'",
+		"commands:\n    ls:\n        help: ls\n        alias: [docs]\n": "grml.yaml:6:17: alias 'docs' of 'ls' collides with builtin 'docs'",
+		// The shell builtins are reserved outside of the shell, too.
+		"commands:\n    history:\n        help: history\n": "grml.yaml:4:5: command 'history' collides with builtin 'history'",
+		"commands:\n    release:\n        help: release\n        options:\n            channel: [stable, beta]\n        commands:\n            options:\n                help: options\n": "grml.yaml:9:13: command 'release.options' collides with builtin 'options'",
+	}
+	for commands, want := range cases {
+		src := "version: 3\nproject: p\n" + commands
+		if err := os.WriteFile(a.manifestPath, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.load(); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", commands, err, want)
+		}
+	}
+}
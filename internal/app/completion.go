@@ -0,0 +1,300 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/desertbit/grumble"
+)
+
+// completionShells are the shells 'completion' emits scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completeCommand is the name of the builtin called by the completion
+// scripts. It is neither listed in the help nor completed.
+const completeCommand = "__complete"
+
+// globalFlagNames are the long names of the global flags offered by the
+// completion.
+var globalFlagNames = []string{"--directory", "--file", "--help", "--nocolor", "--option", "--profile", "--verbose"}
+
+// attachCompletion registers the 'completion' builtin and the hidden
+// '__complete' builtin called by the completion scripts under addCmd.
+func (a *app) attachCompletion(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "completion",
+		Help:     "print the shell completion script for bash, zsh or fish",
+		LongHelp: "Prints the completion script of the shell, e.g. 'source <(grml completion bash)' in ~/.bashrc, 'source <(grml completion zsh)' in ~/.zshrc or 'grml completion fish | source' in the fish config.",
+		Args: func(args *grumble.Args) {
+			args.String("shell", strings.Join(completionShells, ", "))
+		},
+		Completer: func(prefix string, args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			var words []string
+			for _, s := range completionShells {
+				if strings.HasPrefix(s, prefix) {
+					words = append(words, s)
+				}
+			}
+			return words
+		},
+		Run: func(c *grumble.Context) error {
+			switch shell := c.Args.String("shell"); shell {
+			case "bash":
+				a.Print(bashCompletion)
+			case "zsh":
+				a.Print(zshCompletion)
+			case "fish":
+				a.Print(fishCompletion)
+			default:
+				return fmt.Errorf("unknown shell '%s': expected one of %s", shell, strings.Join(completionShells, ", "))
+			}
+			return nil
+		},
+	})
+	addCmd(a.newCompleteCommand())
+}
+
+// newCompleteCommand returns the '__complete' builtin, which prints the
+// completion candidates of the command line one per line.
+func (a *app) newCompleteCommand() *grumble.Command {
+	return &grumble.Command{
+		Name: completeCommand,
+		Help: "print the completion candidates of the command line",
+		Args: func(args *grumble.Args) {
+			args.StringList("words", "command line words after 'grml', the last one is completed")
+		},
+		Run: func(c *grumble.Context) error {
+			for _, w := range a.complete(c.Args.StringList("words")) {
+				a.Println(w)
+			}
+			return nil
+		},
+	}
+}
+
+// complete returns the candidates for the last of the command line words
+// after 'grml'. Candidates ending with '/' or '=' are incomplete.
+func (a *app) complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	prev := words[:len(words)-1]
+
+	// Skip the global flags before the command.
+	i := 0
+	for ; i < len(prev) && strings.HasPrefix(prev[i], "-"); i++ {
+		if !isValueFlag(prev[i]) {
+			continue
+		}
+		if i+1 == len(prev) {
+			return a.completeFlagValue(prev[i], cur)
+		}
+		i++ // Skip the value.
+	}
+	if i == len(prev) && strings.HasPrefix(cur, "-") {
+		return filterPrefix(globalFlagNames, cur)
+	}
+
+	// The commands are completed like in the shell.
+	var line strings.Builder
+	for _, w := range prev[i:] {
+		line.WriteString(quoteWord(w))
+		line.WriteString(" ")
+	}
+	line.WriteString(cur)
+	rs := []rune(line.String())
+	suggestions, _ := a.completer.Do(rs, len(rs))
+
+	candidates := make([]string, 0, len(suggestions))
+	for _, s := range suggestions {
+		candidates = append(candidates, cur+strings.TrimSuffix(string(s), " "))
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// completeFlagValue returns the candidates for the value of the global
+// flag.
+func (a *app) completeFlagValue(flag, cur string) []string {
+	switch flag {
+	case "-d", "--directory":
+		var dirs []string
+		for _, p := range completePath(cur, a.cwd) {
+			if strings.HasSuffix(p, "/") {
+				dirs = append(dirs, p)
+			}
+		}
+		return dirs
+	case "-f", "--file":
+		return completePath(cur, a.rootPath)
+	case "--profile":
+		if a.manifest == nil {
+			return nil
+		}
+		return filterPrefix(a.profileNames(), cur)
+	case "-o", "--option":
+		return a.completeOption(cur)
+	}
+	return nil
+}
+
+// completeOption returns the candidates of a '[command.]name=value'
+// option assignment: the option keys followed by '=' and after the '='
+// the valid values of bool, choice and multi-select options. Multi-select
+// values are separated by commas.
+func (a *app) completeOption(cur string) []string {
+	key, value, ok := strings.Cut(cur, "=")
+	if !ok {
+		var keys []string
+		for sp, o := range a.options {
+			for _, name := range o.Names() {
+				if sp != "" {
+					name = sp + "." + name
+				}
+				keys = append(keys, name+"=")
+			}
+		}
+		sort.Strings(keys)
+		return filterPrefix(keys, cur)
+	}
+
+	sp, name := splitOptionKey(key)
+	o := a.options[sp]
+	if o == nil {
+		return nil
+	}
+	var values []string
+	if _, ok := o.Bools[name]; ok {
+		values = []string{"false", "true"}
+	} else if c, ok := o.Choices[name]; ok {
+		values = c.Options
+	} else if m, ok := o.Multis[name]; ok {
+		// Complete the last of the comma separated values.
+		if i := strings.LastIndex(value, ","); i >= 0 {
+			key += "=" + value[:i+1]
+			value = value[i+1:]
+		} else {
+			key += "="
+		}
+		var candidates []string
+		for _, v := range filterPrefix(m.Options, value) {
+			candidates = append(candidates, key+v)
+		}
+		return candidates
+	}
+
+	var candidates []string
+	for _, v := range filterPrefix(values, value) {
+		candidates = append(candidates, key+"="+v)
+	}
+	return candidates
+}
+
+// quoteWord quotes the word for grumble's shell-like line splitting if it
+// contains blanks or quotes.
+func quoteWord(w string) string {
+	if w == "" || strings.ContainsAny(w, " \t'\"\\") {
+		return strconv.Quote(w)
+	}
+	return w
+}
+
+// isValueFlag returns true if the global flag consumes the next argument.
+func isValueFlag(flag string) bool {
+	for _, f := range valueFlags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPrefix returns the words starting with prefix.
+func filterPrefix(words []string, prefix string) (matches []string) {
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			matches = append(matches, w)
+		}
+	}
+	return
+}
+
+const bashCompletion = `# bash completion for grml. Load it with:
+#   source <(grml completion bash)
+_grml_completion() {
+    local line="${COMP_LINE:0:COMP_POINT}"
+    local -a words
+    read -ra words <<< "$line"
+    [[ "$line" == *" " ]] && words+=("")
+    local cur="${words[${#words[@]}-1]}"
+
+    # bash splits the completed word at '=' and ':'.
+    local prefix="${cur%"${cur##*[=:]}"}"
+
+    local c
+    COMPREPLY=()
+    while IFS= read -r c; do
+        [[ -z "$c" ]] && continue
+        c="${c#"$prefix"}"
+        if [[ "$c" == */ || "$c" == *= ]]; then
+            COMPREPLY+=("$c")
+        else
+            COMPREPLY+=("$c ")
+        fi
+    done < <(grml __complete -- "${words[@]:1}" 2>/dev/null)
+}
+complete -o nospace -F _grml_completion grml
+`
+
+const zshCompletion = `#compdef grml
+# zsh completion for grml. Load it with:
+#   source <(grml completion zsh)
+_grml() {
+    local -a full partial
+    local c
+    for c in "${(@f)$(grml __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -z "$c" ]] && continue
+        if [[ "$c" == */ || "$c" == *= ]]; then
+            partial+=("$c")
+        else
+            full+=("$c")
+        fi
+    done
+    compadd -Q -S '' -- "${partial[@]}"
+    compadd -Q -- "${full[@]}"
+}
+compdef _grml grml
+`
+
+const fishCompletion = `# fish completion for grml. Load it with:
+#   grml completion fish | source
+function __grml_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    grml __complete -- $tokens[2..-1] 2>/dev/null
+end
+complete -c grml -f -a '(__grml_complete)'
+`
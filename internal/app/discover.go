@@ -0,0 +1,57 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findRoot walks up from dir to the nearest directory containing the
+// grml file. The search stops at the root of a git repository or of the
+// filesystem.
+func findRoot(dir, file string) (string, bool) {
+	for {
+		if fileExists(filepath.Join(dir, file)) {
+			return dir, true
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", false
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// fileExists returns true if a file exists at path.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// inSubdir returns true if grml was invoked from a subdirectory of the
+// root directory.
+func (a *app) inSubdir() bool {
+	return strings.HasPrefix(a.cwd, a.rootPath+string(filepath.Separator))
+}
@@ -0,0 +1,55 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"strings"
+
+	"github.com/desertbit/grml/internal/docs"
+	"github.com/desertbit/grumble"
+)
+
+// attachDocs registers the 'docs' builtin under addCmd.
+func (a *app) attachDocs(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "docs",
+		Help:     "print the reference documentation of the commands and options",
+		LongHelp: "Prints the reference documentation of the commands and options with their defaults, e.g. 'grml docs > TASKS.md'. Hidden commands are left out.",
+		Flags: func(f *grumble.Flags) {
+			f.StringL("format", "markdown", "output format: "+strings.Join(docs.Formats, ", "))
+		},
+		Run: func(c *grumble.Context) error {
+			return a.docs(c.Flags.String("format"))
+		},
+	})
+}
+
+// docs prints the documentation in the format. Options are documented
+// with their default values, not the current ones.
+func (a *app) docs(format string) error {
+	defaults, err := a.manifest.ParseOptions()
+	if err != nil {
+		return err
+	}
+	return docs.Generate(a, format, docs.Config{
+		Manifest: a.manifest,
+		Commands: a.commands,
+		Options:  defaults,
+	})
+}
@@ -20,9 +20,12 @@ package app
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/desertbit/grml/internal/cmd"
 )
@@ -31,17 +34,123 @@ import (
 // Only use once.
 type execContext struct {
 	done map[*cmd.Command]struct{}
+
+	// started lists the hook scopes whose before_all hook ran, outermost
+	// first. The first inherited scopes were started by the parent context.
+	started   []startedScope
+	inherited int
+
+	// steps are the commands run so far, reported in the summary.
+	steps []step
+
+	// target is the invoked command with its args, if any.
+	target *cmd.Command
+	args   map[string]string
+
+	// The standard streams of the shell commands.
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
 }
 
 func newExecContext() *execContext {
 	return &execContext{
-		done: make(map[*cmd.Command]struct{}),
+		done:   make(map[*cmd.Command]struct{}),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+}
+
+// fork returns a new context for an independent run, like a fanout target.
+// Hook scopes started by ctx are not started again by the fork.
+func (ctx *execContext) fork() *execContext {
+	f := newExecContext()
+	f.started = append(f.started, ctx.started...)
+	f.inherited = len(f.started)
+	f.stdin = ctx.stdin
+	f.stdout = ctx.stdout
+	f.stderr = ctx.stderr
+	return f
+}
+
+// step is a command run by an execContext.
+type step struct {
+	path     string
+	duration time.Duration
+	err      error
+	skipped  bool // never ran, because a previous step failed
+}
+
+// skippedSteps returns the steps of the commands a run of c executes, its
+// deps depth first and c itself, which are missing in steps.
+func skippedSteps(c *cmd.Command, steps []step) []step {
+	ran := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		ran[s.path] = true
+	}
+
+	var skipped []step
+	var walk func(c *cmd.Command)
+	walk = func(c *cmd.Command) {
+		for _, dc := range c.Deps() {
+			walk(dc)
+		}
+		if !ran[c.Path()] {
+			ran[c.Path()] = true
+			skipped = append(skipped, step{path: c.Path(), skipped: true})
+		}
+	}
+	walk(c)
+	return skipped
+}
+
+func (a *app) exec(c *cmd.Command, args map[string]string) error {
+	run := historyEntry{Command: c.Path(), Args: args}
+	return a.recordRun(run, []*cmd.Command{c}, func() error {
+		return a.execRun(newExecContext(), c, args)
+	})
+}
+
+// execRun runs the command after its dependencies and summarizes the run
+// of a command with deps or a fanout, even if a dependency failed.
+func (a *app) execRun(ctx *execContext, c *cmd.Command, args map[string]string) error {
+	ctx.target, ctx.args = c, args
+	start := time.Now()
+	err := a.execTarget(ctx, c, args)
+	err = a.finish(ctx, err)
+
+	if len(c.Deps()) > 0 || c.HasFanout() {
+		steps := append(ctx.steps, skippedSteps(c, ctx.steps)...)
+		a.printSummary(ctx.stdout, steps, time.Since(start))
 	}
+	return err
 }
 
-func (a *app) exec(c *cmd.Command, args map[string]string) (err error) {
-	ctx := newExecContext()
+// recordRun runs f, the run of the commands cs, and records it in the
+// history with the option values in scope of cs it started with. Like
+// every run, it never starts with an invalid option combination.
+func (a *app) recordRun(run historyEntry, cs []*cmd.Command, f func() error) (err error) {
+	err = a.checkOptions()
+	if err != nil {
+		return
+	}
+
+	start, opts := time.Now(), make(map[string]string)
+	for _, c := range cs {
+		for k, v := range a.optionValues(c) {
+			opts[k] = v
+		}
+	}
+	defer func() {
+		a.record(run, opts, start, err)
+	}()
+
+	return f()
+}
 
+// execTarget runs the command after its dependencies.
+func (a *app) execTarget(ctx *execContext, c *cmd.Command, args map[string]string) (err error) {
 	// Run the dependecny commands.
 	err = a.execCommands(ctx, c.Deps())
 	if err != nil {
@@ -78,39 +187,70 @@ func (a *app) execCommand(ctx *execContext, c *cmd.Command, args map[string]stri
 	}
 
 	// Log.
-	a.printColorln("exec: " + c.Path())
+	a.fprintColorln(ctx.stdout, "exec: "+c.Path())
+
+	// Report the outcome and duration of the step.
+	start := time.Now()
+	defer func() {
+		s := step{path: c.Path(), duration: time.Since(start), err: err}
+		ctx.steps = append(ctx.steps, s)
+		a.printStep(ctx.stdout, s)
+	}()
 
 	// Prepare our execution environment.
-	var env []string
+	env, imports, workdir := a.execSetup(c, args)
+
+	// Run the before hooks of all enclosing scopes.
+	err = a.beforeHooks(ctx, c, env, imports, workdir)
+	if err != nil {
+		return
+	}
+
+	// Run the fanout commands before the command's own exec body.
+	if c.HasFanout() {
+		targets, parallel := c.Fanout()
+		err = a.fanout(ctx, c.Path(), targets, parallel)
+	}
+
+	// Go go go.
+	if err == nil {
+		err = a.runShellCommand(ctx, c.ExecString(), env, imports, c.Functions(), workdir)
+	}
+
+	// The after hooks run regardless of the outcome.
+	err = a.afterEachHooks(ctx, c, env, imports, workdir, err)
+	if err != nil {
+		return
+	}
+
+	// Remember the successfully run target.
+	ctx.done[c] = struct{}{}
+	return
+}
+
+// execSetup returns the process environment, the imports and the working
+// directory of the command's shell bodies.
+func (a *app) execSetup(c *cmd.Command, args map[string]string) (env, imports []string, workdir string) {
 	for k, v := range args {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 	env = append(env, a.execEnv(c)...) // Add args always first.
 
 	// Combine root manifest imports with the command's per-include imports.
-	imports := append([]string{}, a.manifest.Import...)
+	imports = append([]string{}, a.manifest.Import...)
 	imports = append(imports, c.Imports()...)
 
 	// Working dir: subgrml commands run from their own subgrml's directory
 	// (resolved via the scoped LOCAL_ROOT env var); root commands run from
 	// the root directory.
-	workdir := a.rootPath
+	workdir = a.rootPath
 	if lr := a.cmdEnv(c)["LOCAL_ROOT"]; lr != "" {
 		workdir = lr
 	}
-
-	// Go go go.
-	err = a.runShellCommand(c.ExecString(), env, imports, workdir)
-	if err != nil {
-		return
-	}
-
-	// Remember the successfully run target.
-	ctx.done[c] = struct{}{}
 	return
 }
 
-func (a *app) runShellCommand(cmdStr string, env []string, imports []string, workdir string) error {
+func (a *app) runShellCommand(ctx *execContext, cmdStr string, env []string, imports []string, functions map[string]string, workdir string) error {
 	if len(cmdStr) == 0 {
 		return nil
 	}
@@ -123,6 +263,10 @@ func (a *app) runShellCommand(cmdStr string, env []string, imports []string, wor
 	// their definitions don't pollute verbose trace output.
 	prefix.WriteString(grmlBuiltins)
 
+	// Define the manifest's functions next to the builtins, so imports
+	// may use them.
+	prefix.WriteString(shellFunctions(functions))
+
 	// Enable verbose mode if set.
 	if a.verbose {
 		prefix.WriteString("set -x\n")
@@ -150,10 +294,30 @@ func (a *app) runShellCommand(cmdStr string, env []string, imports []string, wor
 	}
 
 	cmd := exec.Command(shell, "-c", prefix.String()+cmdStr)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	cmd.Stdout = ctx.stdout
+	cmd.Stderr = ctx.stderr
+	cmd.Stdin = ctx.stdin
 	cmd.Dir = workdir
 	cmd.Env = env
 	return cmd.Run()
 }
+
+// shellFunctions returns the shell definitions of the functions, sorted
+// by name.
+func shellFunctions(functions map[string]string) string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		body := strings.TrimRight(functions[name], "\n")
+		if strings.TrimSpace(body) == "" {
+			body = ":" // Empty bodies are a syntax error.
+		}
+		fmt.Fprintf(&b, "%s() {\n%s\n}\n", name, body)
+	}
+	return b.String()
+}
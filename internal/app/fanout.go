@@ -0,0 +1,237 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grumble"
+)
+
+// attachForeach registers the 'foreach' builtin under addCmd.
+func (a *app) attachForeach(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "foreach",
+		Help:     "run a command in every included subgrml defining it",
+		LongHelp: "Runs the command, a path relative to each include point, in every included subgrml defining it and prints a summary.",
+		Flags: func(f *grumble.Flags) {
+			f.Bool("p", "parallel", false, "run the commands in parallel")
+		},
+		Args: func(args *grumble.Args) {
+			args.String("command", "path of the command relative to the include points")
+		},
+		Completer: func(prefix string, args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			var words []string
+			for _, path := range a.includePaths() {
+				if strings.HasPrefix(path, prefix) {
+					words = append(words, path)
+				}
+			}
+			return words
+		},
+		Run: func(c *grumble.Context) error {
+			return a.foreach(c.Args.String("command"), c.Flags.Bool("parallel"))
+		},
+	})
+}
+
+// foreach runs the command path relative to the include points in every
+// included subgrml defining it.
+func (a *app) foreach(path string, parallel bool) error {
+	targets := a.commands.IncludeTargets(nil, path)
+	if len(targets) == 0 {
+		return fmt.Errorf("no included subgrml defines '%s'", path)
+	}
+
+	run := historyEntry{Command: path, Foreach: true, Parallel: parallel}
+	return a.recordRun(run, targets, func() error {
+		// The root hooks run once around all targets.
+		ctx := newExecContext()
+		err := a.startRootScope(ctx, targets[0])
+		if err == nil {
+			err = a.fanout(ctx, "foreach "+path, targets, parallel)
+		}
+		return a.finish(ctx, err)
+	})
+}
+
+// includePaths returns the sorted command paths relative to their
+// include point, without args, e.g. 'test' for 'api.test'.
+func (a *app) includePaths() []string {
+	seen := make(map[string]bool)
+	a.commands.Walk(func(c *cmd.Command) {
+		if c.Origin() != "" && !c.IsInclude() && !c.HasArgs() && !c.Hidden() {
+			seen[strings.TrimPrefix(c.Path(), c.Origin()+".")] = true
+		}
+	})
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// fanout runs each target after its dependencies and prints a summary
+// labeled by the targets' include points to the context's stdout. All
+// targets run, even if some fail. Parallel runs get no stdin and their
+// output lines are prefixed with the include point.
+//
+// Each target runs in a fork of ctx and doesn't share the commands run
+// with the other targets: a dependency of several targets, like a root
+// build command, runs once per target. Parallel targets could only share
+// it by waiting for each other.
+func (a *app) fanout(ctx *execContext, name string, targets cmd.Commands, parallel bool) error {
+	if len(targets) == 0 {
+		fmt.Fprintf(ctx.stdout, "%s: no included subgrml defines the command\n", name)
+		return nil
+	}
+
+	errs := make([]error, len(targets))
+	steps := make([][]step, len(targets))
+	if parallel {
+		var (
+			wg    sync.WaitGroup
+			mutex sync.Mutex
+		)
+		for i, t := range targets {
+			wg.Add(1)
+			go func(i int, t *cmd.Command) {
+				defer wg.Done()
+
+				prefix := "[" + t.Origin() + "] "
+				stdout := &prefixWriter{w: ctx.stdout, prefix: prefix, mutex: &mutex}
+				stderr := &prefixWriter{w: ctx.stderr, prefix: prefix, mutex: &mutex}
+
+				tctx := ctx.fork()
+				tctx.target = t
+				tctx.stdin = nil
+				tctx.stdout = stdout
+				tctx.stderr = stderr
+				errs[i] = a.finish(tctx, a.execTarget(tctx, t, nil))
+				steps[i] = tctx.steps
+
+				stdout.Flush()
+				stderr.Flush()
+			}(i, t)
+		}
+		wg.Wait()
+	} else {
+		for i, t := range targets {
+			tctx := ctx.fork()
+			tctx.target = t
+			errs[i] = a.finish(tctx, a.execTarget(tctx, t, nil))
+			steps[i] = tctx.steps
+		}
+	}
+
+	// The steps of the targets are part of the run's summary.
+	for _, s := range steps {
+		ctx.steps = append(ctx.steps, s...)
+	}
+
+	// Print the summary.
+	var (
+		failed   int
+		firstErr error
+		output   = make([]string, len(targets))
+	)
+	for i, t := range targets {
+		if errs[i] != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			output[i] = fmt.Sprintf("%s|FAIL|%v", t.Origin(), errs[i])
+		} else {
+			output[i] = fmt.Sprintf("%s|ok", t.Origin())
+		}
+	}
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	config.Prefix = "  "
+
+	fmt.Fprintln(ctx.stdout)
+	a.fprintColorln(ctx.stdout, name+":")
+	fmt.Fprintln(ctx.stdout, columnize.Format(output, config))
+
+	// Wrap the first failure to exit with its status.
+	if failed > 0 {
+		return fmt.Errorf("%s: %d of %d failed: %w", name, failed, len(targets), firstErr)
+	}
+	return nil
+}
+
+// prefixWriter writes complete lines prefixed with prefix to w. Writes of
+// all prefixWriters sharing the mutex are serialized.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mutex  *sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+
+	// Write all complete lines.
+	data := p.buf.Bytes()
+	i := bytes.LastIndexByte(data, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	err := p.write(data[:i+1])
+	p.buf.Next(i + 1)
+	return len(b), err
+}
+
+// Flush writes a trailing incomplete line.
+func (p *prefixWriter) Flush() {
+	if p.buf.Len() > 0 {
+		p.buf.WriteByte('\n')
+		_ = p.write(p.buf.Bytes())
+		p.buf.Reset()
+	}
+}
+
+func (p *prefixWriter) write(lines []byte) error {
+	var out bytes.Buffer
+	for _, l := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(l) > 0 {
+			out.WriteString(p.prefix)
+			out.Write(l)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, err := p.w.Write(out.Bytes())
+	return err
+}
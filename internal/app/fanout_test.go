@@ -0,0 +1,174 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var (
+		out   bytes.Buffer
+		mutex sync.Mutex
+	)
+	w := &prefixWriter{w: &out, prefix: "[api] ", mutex: &mutex}
+
+	for _, s := range []string{"one\ntw", "o\n", "", "three\nfour\n\nfi", "ve"} {
+		if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("write %q: %d, %v", s, n, err)
+		}
+	}
+	// Only complete lines are written before the flush.
+	want := "[api] one\n[api] two\n[api] three\n[api] four\n[api] \n"
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+
+	w.Flush()
+	want += "[api] five\n"
+	if out.String() != want {
+		t.Fatalf("flushed %q, want %q", out.String(), want)
+	}
+	w.Flush()
+	if out.String() != want {
+		t.Fatalf("flushed twice %q, want %q", out.String(), want)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+includes: "*/grml.yaml"
+commands:
+    build:
+        help: build
+        exec: echo build
+`,
+		"api/grml.yaml": "commands:\n    test:\n        help: test\n        deps: [build]\n        exec: echo api\n",
+		"db/grml.yaml":  "commands:\n    test:\n        help: test\n        exec: exit 3\n",
+		"web/grml.yaml": "commands:\n    test:\n        help: test\n        deps: [build]\n        exec: echo web\n",
+	})
+	targets := a.commands.IncludeTargets(nil, "test")
+
+	for _, parallel := range []bool{false, true} {
+		var out bytes.Buffer
+		ctx := newTestContext(&out)
+		err := a.fanout(ctx, "foreach test", targets, parallel)
+		if err == nil || err.Error() != "foreach test: 1 of 3 failed: exit status 3" {
+			t.Errorf("parallel=%v: error %v", parallel, err)
+		}
+		if s := exitStatus(err); s != 3 {
+			t.Errorf("parallel=%v: exit status %d, want 3", parallel, s)
+		}
+
+		// The shared build dep runs for each target.
+		if n := strings.Count(out.String(), "exec: build\n"); n != 2 {
+			t.Errorf("parallel=%v: build ran %d times:\n%s", parallel, n, out.String())
+		}
+		if len(ctx.steps) != 5 {
+			t.Errorf("parallel=%v: %d steps", parallel, len(ctx.steps))
+		}
+		if parallel && (!strings.Contains(out.String(), "[api] api\n") || !strings.Contains(out.String(), "[api] exec: api.test\n")) {
+			t.Errorf("parallel output not prefixed:\n%s", out.String())
+		}
+
+		summary := out.String()[strings.Index(out.String(), "foreach test:\n"):]
+		want := "foreach test:\n  api  ok\n  db   FAIL  exit status 3\n  web  ok\n"
+		if summary != want {
+			t.Errorf("parallel=%v: summary\n%q\nwant\n%q", parallel, summary, want)
+		}
+	}
+}
+
+func TestForeachHooks(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+includes: "*/grml.yaml"
+hooks:
+    before_all: echo before_all >> "${ROOT}/hooks.log"
+    before_each: echo before_each >> "${ROOT}/hooks.log"
+    after_each: echo after_each >> "${ROOT}/hooks.log"
+    after_all: echo after_all >> "${ROOT}/hooks.log"
+`,
+		"api/grml.yaml": "hooks:\n    before_all: echo api >> \"${ROOT}/hooks.log\"\ncommands:\n    test:\n        help: test\n        exec: \"true\"\n",
+		"web/grml.yaml": "commands:\n    test:\n        help: test\n        exec: \"true\"\n",
+	})
+
+	for _, args := range [][]string{{"foreach", "test"}, {"foreach", "-p", "test"}} {
+		log := filepath.Join(a.rootPath, "hooks.log")
+		os.Remove(log)
+		if err := a.RunCommand(args); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		data, err := os.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The root scope runs once around all targets, the api scope once
+		// for its target.
+		counts := make(map[string]int)
+		for _, l := range strings.Fields(string(data)) {
+			counts[l]++
+		}
+		want := map[string]int{"before_all": 1, "after_all": 1, "before_each": 2, "after_each": 2, "api": 1}
+		if !reflect.DeepEqual(counts, want) {
+			t.Errorf("%v: hook runs %v, want %v", args, counts, want)
+		}
+		lines := strings.Fields(string(data))
+		if lines[0] != "before_all" || lines[len(lines)-1] != "after_all" {
+			t.Errorf("%v: hook order %v", args, lines)
+		}
+	}
+}
+
+func TestAfterAllTarget(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+hooks:
+    after_all: echo "${GRML_COMMAND}" > "${ROOT}/after.log"
+commands:
+    mx:
+        help: matrix
+        matrix:
+            X: [1, 2]
+        exec: "true"
+`,
+	})
+
+	if err := a.RunCommand([]string{"mx"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(a.rootPath, "after.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "mx" {
+		t.Errorf("after_all GRML_COMMAND %q, want mx", got)
+	}
+}
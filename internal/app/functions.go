@@ -0,0 +1,136 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grumble"
+)
+
+// attachFunctions registers the 'functions' builtin under addCmd.
+func (a *app) attachFunctions(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "functions",
+		Help:     "list the shell functions or print one",
+		LongHelp: "Lists the shell functions of the grml file and its includes or prints the definition of the function addressed by '[command.]name'.",
+		Args: func(args *grumble.Args) {
+			args.String("name", "[command.]name of the function to print", grumble.Default(""))
+		},
+		Completer: func(prefix string, args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			var words []string
+			for _, key := range a.functionKeys() {
+				if strings.HasPrefix(key, prefix) {
+					words = append(words, key)
+				}
+			}
+			return words
+		},
+		Run: func(c *grumble.Context) error {
+			key := c.Args.String("name")
+			if key == "" {
+				a.printFunctions()
+				return nil
+			}
+			scopePath, name := splitOptionKey(key)
+			body, ok := a.functionScopes()[scopePath][name]
+			if !ok {
+				return fmt.Errorf("function '%s': does not exist", key)
+			}
+			a.Print(shellFunctions(map[string]string{name: body}))
+			return nil
+		},
+	})
+}
+
+// functionScopes returns the declared functions by scope path. The
+// manifest's scope is "".
+func (a *app) functionScopes() map[string]map[string]string {
+	scopes := make(map[string]map[string]string)
+	if len(a.manifest.Functions) > 0 {
+		scopes[""] = a.manifest.Functions
+	}
+	a.commands.Walk(func(c *cmd.Command) {
+		if fns := c.DeclaredFunctions(); len(fns) > 0 {
+			scopes[c.Path()] = fns
+		}
+	})
+	return scopes
+}
+
+// functionKeys returns the sorted '[command.]name' keys of all functions.
+func (a *app) functionKeys() []string {
+	var keys []string
+	for scopePath, fns := range a.functionScopes() {
+		for name := range fns {
+			if scopePath != "" {
+				name = scopePath + "." + name
+			}
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printFunctions prints the functions of each scope with the first line
+// of their bodies.
+func (a *app) printFunctions() {
+	scopes := a.functionScopes()
+	paths := make([]string, 0, len(scopes))
+	for p := range scopes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	config.Prefix = "  "
+
+	fmt.Println()
+	for _, p := range paths {
+		fns := scopes[p]
+		names := make([]string, 0, len(fns))
+		for name := range fns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		output := make([]string, 0, len(names))
+		for _, name := range names {
+			first, _, _ := strings.Cut(strings.TrimSpace(fns[name]), "\n")
+			output = append(output, fmt.Sprintf("%s | %s", name, first))
+		}
+
+		title := "Functions:"
+		if p != "" {
+			title = p + " Functions:"
+		}
+		a.printColorln(title + "\n")
+		fmt.Printf("%s\n\n", columnize.Format(output, config))
+	}
+}
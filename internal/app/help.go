@@ -0,0 +1,97 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/cmd"
+)
+
+// helpGroup returns the grumble help group of the command group. The
+// commands without a group are listed below "Commands:".
+func helpGroup(group string) string {
+	group = strings.TrimSpace(strings.TrimSuffix(group, ":"))
+	if group == "" {
+		return ""
+	}
+	return group + ":"
+}
+
+// longHelp returns the help of 'help <command>': the description followed
+// by the deps, the options in scope and where the command was declared.
+// grumble appends the args, flags and sub commands.
+func (a *app) longHelp(c *cmd.Command) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(a.evalVar(a.cmdEnv(c), c.Description())))
+
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	config.Prefix = "  "
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		b.WriteString("\n\n")
+		b.WriteString(a.headline(title))
+		b.WriteString("\n")
+		b.WriteString(columnize.Format(lines, config))
+	}
+
+	deps := append([]string{}, c.DepPaths()...)
+	if f := c.FanoutPath(); f != "" {
+		deps = append(deps, fmt.Sprintf("%s | in every include (fanout)", f))
+	}
+	section("Deps:", deps)
+
+	var opts []string
+	for _, sp := range a.activeOptionScopes(c.Path()) {
+		o := a.options[sp]
+		for _, name := range o.Names() {
+			key := name
+			if sp != "" {
+				key = sp + "." + name
+			}
+			opts = append(opts, fmt.Sprintf("%s | %s", key, o.Help(name)))
+		}
+	}
+	section("Options:", opts)
+
+	source := []string{c.Pos().String()}
+	if c.Include() != "" {
+		source = append(source, c.Include()+" | included")
+	}
+	section("Source:", source)
+
+	return b.String()
+}
+
+// headline returns the title underlined like grumble's help headlines.
+func (a *app) headline(title string) string {
+	underline := strings.Repeat("=", len(title))
+	conf := a.Config()
+	if conf.NoColor || conf.HelpHeadlineColor == nil {
+		return title + "\n" + underline
+	}
+	return conf.HelpHeadlineColor.Sprint(title) + "\n" + conf.HelpHeadlineColor.Sprint(underline)
+}
@@ -0,0 +1,353 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grumble"
+)
+
+const (
+	// historyFile stores the runs of the shell, relative to the root.
+	historyFile = ".grml/history"
+
+	// historyLimit is the number of runs kept in the history file.
+	historyLimit = 1000
+)
+
+// historyEntry is a command run in the shell. It's stored as one json
+// line in the history file. The command of a foreach run is the path
+// relative to the include points.
+type historyEntry struct {
+	Time     time.Time         `json:"time"`
+	Command  string            `json:"command"`
+	Foreach  bool              `json:"foreach,omitempty"`
+	Parallel bool              `json:"parallel,omitempty"`
+	Args     map[string]string `json:"args,omitempty"`
+	Profile  string            `json:"profile,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+	Duration int64             `json:"duration_ms"`
+	Status   int               `json:"status"`
+}
+
+// attachHistory registers the 'history', 'last' and 'rerun' builtins
+// under addCmd.
+func (a *app) attachHistory(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "history",
+		Help:     "list the command runs with their status and duration",
+		LongHelp: "Lists the command runs of the shell, which are kept in " + historyFile + " below the root directory. Use the run numbers with 'rerun'.",
+		Flags: func(f *grumble.Flags) {
+			f.Int("n", "number", 20, "number of runs to list, 0 for all")
+		},
+		Run: func(c *grumble.Context) error {
+			a.printHistory(c.Flags.Int("number"))
+			return nil
+		},
+	})
+	addCmd(&grumble.Command{
+		Name: "last",
+		Help: "print the last command run with its args and options",
+		Run: func(c *grumble.Context) error {
+			if len(a.history) == 0 {
+				return fmt.Errorf("no runs in the history")
+			}
+			a.printRun(len(a.history))
+			return nil
+		},
+	})
+	addCmd(&grumble.Command{
+		Name:     "rerun",
+		Help:     "run a command of the history again",
+		LongHelp: "Runs the command of run n, the last one by default, again with the same args and the current option values. With --options the option values and profile of the run are restored first.",
+		Flags: func(f *grumble.Flags) {
+			f.BoolL("options", false, "restore the option values of the run")
+		},
+		Args: func(args *grumble.Args) {
+			args.Int("n", "run number, see 'history'", grumble.Default(0))
+		},
+		Run: func(c *grumble.Context) error {
+			return a.rerun(c.Args.Int("n"), c.Flags.Bool("options"))
+		},
+	})
+}
+
+// record adds the run to the history of the shell and appends it to the
+// history file. Runs outside of the shell aren't recorded.
+func (a *app) record(e historyEntry, opts map[string]string, start time.Time, err error) {
+	if !a.IsShell() {
+		return
+	}
+	e.Time = start
+	e.Profile = a.profile
+	e.Options = opts
+	e.Duration = time.Since(start).Milliseconds()
+	e.Status = exitStatus(err)
+	a.history = append(a.history, e)
+
+	if werr := a.appendHistory(e); werr != nil {
+		a.Printf("warning: history: %v\n", werr)
+	}
+}
+
+// optionValues returns the current values of the options in scope of c,
+// keyed by '[command.]name' like the -o flag.
+func (a *app) optionValues(c *cmd.Command) map[string]string {
+	values := make(map[string]string)
+	for _, sp := range a.activeOptionScopes(c.Path()) {
+		for name, v := range a.options[sp].Env() {
+			if sp != "" {
+				name = sp + "." + name
+			}
+			values[name] = v
+		}
+	}
+	return values
+}
+
+// readHistory loads the history file. Malformed lines are skipped and
+// the file is truncated to the history limit.
+func (a *app) readHistory() error {
+	path := filepath.Join(a.rootPath, historyFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var history []historyEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var e historyEntry
+		if json.Unmarshal(s.Bytes(), &e) == nil && e.Command != "" {
+			history = append(history, e)
+		}
+	}
+	if err = s.Err(); err != nil {
+		return err
+	}
+
+	a.history = history
+	if len(history) <= historyLimit {
+		return nil
+	}
+	a.history = history[len(history)-historyLimit:]
+	return a.writeHistory()
+}
+
+// writeHistory replaces the history file with the history of the shell.
+func (a *app) writeHistory() error {
+	var b strings.Builder
+	for _, e := range a.history {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(filepath.Join(a.rootPath, historyFile), []byte(b.String()), 0644)
+}
+
+// appendHistory appends the run to the history file.
+func (a *app) appendHistory(e historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.rootPath, historyFile)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rerun runs the command of the run n again, the last one if n is 0.
+func (a *app) rerun(n int, restoreOptions bool) error {
+	if n == 0 {
+		n = len(a.history)
+	}
+	if n < 1 || n > len(a.history) {
+		return fmt.Errorf("no run %d in the history", n)
+	}
+	e := a.history[n-1]
+
+	var c *cmd.Command
+	if e.Foreach {
+		if len(a.commands.IncludeTargets(nil, e.Command)) == 0 {
+			return fmt.Errorf("run %d: no included subgrml defines '%s' anymore", n, e.Command)
+		}
+	} else {
+		var err error
+		c, err = a.commands.Lookup(nil, e.Command)
+		if err != nil {
+			return fmt.Errorf("run %d: command '%s' no longer exists", n, e.Command)
+		}
+		for _, arg := range c.Args() {
+			if _, ok := e.Args[arg]; !ok {
+				return fmt.Errorf("run %d: command '%s' has the new arg '%s'", n, e.Command, arg)
+			}
+		}
+	}
+
+	if restoreOptions {
+		err := a.updateOptions(func() error {
+			keys := make([]string, 0, len(e.Options))
+			for k := range e.Options {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := a.setOption(k, e.Options[k]); err != nil {
+					return fmt.Errorf("run %d: %v", n, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		a.profile = e.Profile
+	}
+
+	a.printColorln("rerun: " + e.commandLine(c))
+	if e.Foreach {
+		return a.foreach(e.Command, e.Parallel)
+	}
+	return a.exec(c, e.Args)
+}
+
+// printHistory prints the last limit runs, all if limit is 0.
+func (a *app) printHistory(limit int) {
+	first := 0
+	if limit > 0 && len(a.history) > limit {
+		first = len(a.history) - limit
+	}
+
+	var lines []string
+	for i := first; i < len(a.history); i++ {
+		e := a.history[i]
+		c, _ := a.commands.Lookup(nil, e.Command)
+		lines = append(lines, fmt.Sprintf("%d | %s | %s | %s | %s",
+			i+1, e.Time.Format("2006-01-02 15:04:05"), e.commandLine(c), e.duration(), e.status()))
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	a.Println(columnize.Format(lines, config))
+}
+
+// printRun prints the details of the run n.
+func (a *app) printRun(n int) {
+	e := a.history[n-1]
+	c, _ := a.commands.Lookup(nil, e.Command)
+
+	lines := []string{
+		fmt.Sprintf("Run: | %d", n),
+		fmt.Sprintf("Command: | %s", e.commandLine(c)),
+		fmt.Sprintf("Started: | %s", e.Time.Format("2006-01-02 15:04:05")),
+		fmt.Sprintf("Duration: | %s", e.duration()),
+		fmt.Sprintf("Status: | %s", e.status()),
+	}
+	if e.Profile != "" {
+		lines = append(lines, fmt.Sprintf("Profile: | %s", e.Profile))
+	}
+
+	keys := make([]string, 0, len(e.Options))
+	for k := range e.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		title := ""
+		if i == 0 {
+			title = "Options:"
+		}
+		lines = append(lines, fmt.Sprintf("%s | %s=%s", title, k, e.Options[k]))
+	}
+
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	a.Println(columnize.Format(lines, config))
+}
+
+// commandLine returns the shell command line of the run. The args are
+// ordered like the args of c, if it still exists.
+func (e historyEntry) commandLine(c *cmd.Command) string {
+	if e.Foreach {
+		if e.Parallel {
+			return "foreach -p " + e.Command
+		}
+		return "foreach " + e.Command
+	}
+
+	words := strings.Split(e.Command, ".")
+
+	var names []string
+	if c != nil {
+		names = c.Args()
+	} else {
+		for name := range e.Args {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		words = append(words, quoteWord(e.Args[name]))
+	}
+	return strings.Join(words, " ")
+}
+
+func (e historyEntry) duration() string {
+	return formatDuration(time.Duration(e.Duration) * time.Millisecond)
+}
+
+func (e historyEntry) status() string {
+	if e.Status == 0 {
+		return "ok"
+	}
+	return "exit " + strconv.Itoa(e.Status)
+}
@@ -0,0 +1,154 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const historyManifest = `version: 3
+project: p
+options:
+    debug: {type: bool, default: false, help: debug}
+    jobs: {type: int, default: 4, help: jobs}
+commands:
+    build:
+        help: build
+        args: [target]
+        exec: echo build
+`
+
+func TestHistoryFile(t *testing.T) {
+	a := newTestApp(t, map[string]string{"grml.yaml": historyManifest})
+	start := time.Date(2026, 10, 19, 10, 2, 11, 0, time.UTC)
+	want := []historyEntry{
+		{Time: start, Command: "build", Args: map[string]string{"target": "a b"}, Options: map[string]string{"debug": "true"}, Duration: 1200, Status: 1},
+		{Time: start.Add(time.Minute), Command: "test", Foreach: true, Parallel: true, Profile: "ci", Duration: 300},
+	}
+	for _, e := range want {
+		if err := a.appendHistory(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Malformed lines are skipped.
+	path := filepath.Join(a.rootPath, historyFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "{broken")
+	f.Close()
+
+	a.history = nil
+	if err := a.readHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.history, want) {
+		t.Errorf("history\n%+v\nwant\n%+v", a.history, want)
+	}
+	if l := a.history[0].commandLine(nil); l != `build "a b"` {
+		t.Errorf("command line %q", l)
+	}
+	if l := a.history[1].commandLine(nil); l != "foreach -p test" {
+		t.Errorf("command line %q", l)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	a := newTestApp(t, map[string]string{"grml.yaml": historyManifest})
+	var b strings.Builder
+	for i := 0; i < historyLimit+5; i++ {
+		fmt.Fprintf(&b, "{\"command\":\"build\",\"args\":{\"target\":\"%d\"}}\n", i)
+	}
+	path := filepath.Join(a.rootPath, historyFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.readHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.history) != historyLimit || a.history[0].Args["target"] != "5" {
+		t.Fatalf("%d runs, first %v", len(a.history), a.history[0].Args)
+	}
+
+	// The file is truncated, too.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != historyLimit {
+		t.Errorf("%d lines in the history file", n)
+	}
+}
+
+func TestRerun(t *testing.T) {
+	a := newTestApp(t, map[string]string{"grml.yaml": historyManifest})
+	a.history = []historyEntry{
+		{Command: "build", Args: map[string]string{"target": "x"}, Options: map[string]string{"debug": "true", "jobs": "8"}},
+		{Command: "build", Options: map[string]string{"jobs": "8"}},
+		{Command: "gone"},
+		{Command: "test", Foreach: true},
+		{Command: "build", Args: map[string]string{"target": "x"}, Options: map[string]string{"jobs": "many"}},
+	}
+
+	// Without --options the current values are kept.
+	if err := a.rerun(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if env := a.options[""].Env(); env["debug"] != "false" || env["jobs"] != "4" {
+		t.Errorf("options changed: %v", env)
+	}
+
+	if err := a.rerun(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if env := a.options[""].Env(); env["debug"] != "true" || env["jobs"] != "8" {
+		t.Errorf("options not restored: %v", env)
+	}
+
+	for n, want := range map[int]string{
+		2: "run 2: command 'build' has the new arg 'target'",
+		3: "run 3: command 'gone' no longer exists",
+		4: "run 4: no included subgrml defines 'test' anymore",
+		6: "no run 6 in the history",
+	} {
+		if err := a.rerun(n, true); err == nil || err.Error() != want {
+			t.Errorf("rerun %d: error %v, want %s", n, err, want)
+		}
+	}
+
+	// Invalid option values of a run are rolled back.
+	if err := a.rerun(5, true); err == nil {
+		t.Error("no error for an invalid option value")
+	}
+	if env := a.options[""].Env(); env["jobs"] != "8" {
+		t.Errorf("options not rolled back: %v", env)
+	}
+}
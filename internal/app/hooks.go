@@ -0,0 +1,182 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/desertbit/grml/internal/cmd"
+)
+
+// startedScope is a hook scope whose before_all hook ran.
+type startedScope struct {
+	scope cmd.HookScope
+
+	// The command whose environment is used for the scope's after_all
+	// hook: the invoked target if it's in the scope, otherwise the first
+	// command of the scope.
+	c       *cmd.Command
+	env     []string
+	imports []string
+	workdir string
+}
+
+// isStarted returns true if the before_all hook of the scope ran already.
+func (ctx *execContext) isStarted(s cmd.HookScope) bool {
+	for _, ss := range ctx.started {
+		if ss.scope.Path == s.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// beforeHooks runs the before_all hooks of the not yet started scopes of
+// the command and the before_each hooks of all of them, outermost first.
+func (a *app) beforeHooks(ctx *execContext, c *cmd.Command, env, imports []string, workdir string) (err error) {
+	for _, s := range c.Hooks() {
+		if !ctx.isStarted(s) {
+			err = a.startScope(ctx, c, s, env, imports, workdir)
+			if err != nil {
+				return
+			}
+		}
+
+		err = a.runHook(ctx, c, s, "before_each", s.Hooks.BeforeEach, env, imports, workdir)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// startRootScope runs the before_all hook of the manifest for c, like
+// running c would. Runs without a command of their own, like foreach,
+// start it once, so the forks of ctx running the targets don't.
+func (a *app) startRootScope(ctx *execContext, c *cmd.Command) error {
+	for _, s := range c.Hooks() {
+		if s.Path == "" && !ctx.isStarted(s) {
+			env, imports, workdir := a.execSetup(c, nil)
+			return a.startScope(ctx, c, s, env, imports, workdir)
+		}
+	}
+	return nil
+}
+
+// startScope runs the before_all hook of the scope and marks it started.
+// The environment of the context's target, or of the command if the target
+// isn't in the scope, is kept for the scope's after_all hook.
+func (a *app) startScope(ctx *execContext, c *cmd.Command, s cmd.HookScope, env, imports []string, workdir string) error {
+	err := a.runHook(ctx, c, s, "before_all", s.Hooks.BeforeAll, env, imports, workdir)
+	if err != nil {
+		return err
+	}
+	if t := ctx.target; t != nil && t != c && inScope(t, s) {
+		c = t
+		env, imports, workdir = a.execSetup(t, ctx.args)
+	}
+	ctx.started = append(ctx.started, startedScope{
+		scope:   s,
+		c:       c,
+		env:     env,
+		imports: imports,
+		workdir: workdir,
+	})
+	return nil
+}
+
+// inScope returns true if the hook scope s encloses the command c.
+func inScope(c *cmd.Command, s cmd.HookScope) bool {
+	for _, cs := range c.Hooks() {
+		if cs.Path == s.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// afterEachHooks runs the after_each hooks of the command's scopes,
+// innermost first. cmdErr is the result of the command and is returned
+// if set, otherwise the first hook error is.
+func (a *app) afterEachHooks(ctx *execContext, c *cmd.Command, env, imports []string, workdir string, cmdErr error) error {
+	env = append(env, fmt.Sprintf("GRML_STATUS=%d", exitStatus(cmdErr)))
+
+	err := cmdErr
+	hooks := c.Hooks()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		s := hooks[i]
+		herr := a.runHook(ctx, c, s, "after_each", s.Hooks.AfterEach, env, imports, workdir)
+		if err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+// finish runs the after_all hooks of the scopes started by the context,
+// innermost first. runErr is the result of the run and is returned if
+// set, otherwise the first hook error is.
+func (a *app) finish(ctx *execContext, runErr error) error {
+	status := fmt.Sprintf("GRML_STATUS=%d", exitStatus(runErr))
+
+	err := runErr
+	for i := len(ctx.started) - 1; i >= ctx.inherited; i-- {
+		ss := ctx.started[i]
+		env := append(append([]string{}, ss.env...), status)
+		herr := a.runHook(ctx, ss.c, ss.scope, "after_all", ss.scope.Hooks.AfterAll, env, ss.imports, ss.workdir)
+		if err == nil {
+			err = herr
+		}
+	}
+	ctx.started = ctx.started[:ctx.inherited]
+	return err
+}
+
+// runHook runs a hook body of the scope for the command c.
+// GRML_COMMAND is set to the command's path.
+func (a *app) runHook(ctx *execContext, c *cmd.Command, s cmd.HookScope, name, body string, env, imports []string, workdir string) error {
+	if body == "" {
+		return nil
+	}
+
+	env = append(append([]string{}, env...), "GRML_COMMAND="+c.Path())
+	err := a.runShellCommand(ctx, body, env, imports, c.Functions(), workdir)
+	if err != nil {
+		scope := s.Path
+		if scope == "" {
+			scope = "manifest"
+		}
+		return fmt.Errorf("hook %s of '%s': %w", name, scope, err)
+	}
+	return nil
+}
+
+// exitStatus returns the exit code of a shell command error.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
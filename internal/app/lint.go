@@ -0,0 +1,97 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grml/internal/lint"
+)
+
+// shellBuiltins are the names of the top-level builtins only added in the
+// shell. They are reserved outside of it, too, so a grml file doesn't
+// break in the shell.
+var shellBuiltins = []string{"clear", "exit", "history", "last", "rerun"}
+
+// builtinNames returns the sorted names and aliases of the top-level
+// builtins registered so far and of the shell builtins.
+func (a *app) builtinNames() []string {
+	names := append([]string{}, shellBuiltins...)
+	for _, c := range a.Commands().All() {
+		names = append(names, c.Name)
+		names = append(names, c.Aliases...)
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// checkBuiltinNames returns an error if a command of cs or one of its
+// aliases collides with a builtin, which grumble would silently prefer.
+// Sub commands only collide with the options builtin of their parent's
+// options scope.
+func (a *app) checkBuiltinNames(cs cmd.Commands, builtins []string) error {
+	for _, c := range cs {
+		if slices.Contains(builtins, c.Name()) {
+			return c.Pos().Errorf("command '%s' collides with builtin '%s'", c.Path(), c.Name())
+		}
+		for i, alias := range c.Alias() {
+			if slices.Contains(builtins, alias) {
+				return c.PosOf("alias", i).Errorf("alias '%s' of '%s' collides with builtin '%s'", alias, c.Path(), alias)
+			}
+		}
+
+		var subBuiltins []string
+		if _, ok := a.options[c.Path()]; ok {
+			subBuiltins = []string{"options"}
+		}
+		if err := a.checkBuiltinNames(c.SubCommands(), subBuiltins); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check lints the manifest and its includes and prints all problems.
+func (a *app) check() error {
+	// Without a loaded grml file only the builtins are registered.
+	builtins := a.builtins
+	if builtins == nil {
+		builtins = a.builtinNames()
+	}
+
+	problems := lint.Run(lint.Config{
+		Path:     a.manifestPath,
+		Overlays: a.overlayPaths,
+		Env:      a.baseEnv(),
+		Implicit: []string{"ROOT", "PROJECT", "NUMCPU", "LOCAL_ROOT", "GRML_CWD", "GRML_COMMAND", "GRML_STATUS"},
+		Builtins: builtins,
+	})
+	if len(problems) == 0 {
+		a.Println("no problems found")
+		return nil
+	}
+
+	for _, p := range problems {
+		a.Println(p)
+	}
+	return fmt.Errorf("%d problem(s) found", len(problems))
+}
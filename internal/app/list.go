@@ -0,0 +1,170 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grumble"
+)
+
+// listFormats are the output formats of the 'list' builtin.
+var listFormats = []string{"text", "json", "tsv"}
+
+// listEntry is a command of the 'list' builtin. The fields and their order
+// are part of the json and tsv output and must stay stable.
+type listEntry struct {
+	Path    string   `json:"path"`
+	Aliases []string `json:"aliases"`
+	Help    string   `json:"help"`
+	Group   string   `json:"group"`
+	Args    []string `json:"args"`
+	Deps    []string `json:"deps"`
+	Fanout  string   `json:"fanout"`
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Origin  string   `json:"origin"`
+	Include string   `json:"include"`
+	Options []string `json:"options"`
+}
+
+// attachList registers the 'list' builtin under addCmd.
+func (a *app) attachList(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "list",
+		Help:     "list all commands, also as json or tsv for other tools",
+		LongHelp: "Lists every command path with its aliases, help, args, deps, source file and option scopes. Hidden commands are left out. The json and tsv formats are stable for tools like IDE task integrations or launchers, e.g. 'grml list --format tsv | fzf'.",
+		Flags: func(f *grumble.Flags) {
+			f.BoolL("json", false, "shorthand for --format json")
+			f.StringL("format", "text", "output format: "+strings.Join(listFormats, ", "))
+		},
+		Run: func(c *grumble.Context) error {
+			format := c.Flags.String("format")
+			if c.Flags.Bool("json") {
+				format = "json"
+			}
+			return a.list(a, format)
+		},
+	})
+}
+
+// list writes the visible commands sorted by path in the format to w.
+func (a *app) list(w io.Writer, format string) error {
+	var entries []listEntry
+	a.commands.Walk(func(c *cmd.Command) {
+		if c.Hidden() || a.hasHiddenParent(c) {
+			return
+		}
+		entries = append(entries, a.newListEntry(c))
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	switch format {
+	case "text":
+		lines := make([]string, 0, len(entries))
+		for _, e := range entries {
+			lines = append(lines, fmt.Sprintf("%s | %s", e.Path, e.Help))
+		}
+		config := columnize.DefaultConfig()
+		config.Delim = "|"
+		config.Glue = "  "
+		fmt.Fprintln(w, columnize.Format(lines, config))
+	case "json":
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case "tsv":
+		for _, e := range entries {
+			fmt.Fprintln(w, strings.Join([]string{
+				tsvField(e.Path),
+				tsvField(strings.Join(e.Aliases, ",")),
+				tsvField(e.Help),
+				tsvField(e.Group),
+				tsvField(strings.Join(e.Args, ",")),
+				tsvField(strings.Join(e.Deps, ",")),
+				tsvField(e.Fanout),
+				tsvField(e.File),
+				fmt.Sprint(e.Line),
+				tsvField(e.Origin),
+				tsvField(e.Include),
+				tsvField(strings.Join(e.Options, ",")),
+			}, "\t"))
+		}
+	default:
+		return fmt.Errorf("unknown format '%s': expected one of %s", format, strings.Join(listFormats, ", "))
+	}
+	return nil
+}
+
+// newListEntry returns the list entry of c. Its help is evaluated with
+// the variables in c's scope like in the shell and the deps are resolved
+// to absolute paths. Slices are never nil to print them as empty json
+// arrays.
+func (a *app) newListEntry(c *cmd.Command) listEntry {
+	e := listEntry{
+		Path:    c.Path(),
+		Aliases: append([]string{}, c.Alias()...),
+		Help:    strings.TrimSpace(a.evalVar(a.cmdEnv(c), c.Help())),
+		Group:   c.Group(),
+		Args:    append([]string{}, c.Args()...),
+		Deps:    []string{},
+		Fanout:  c.FanoutPath(),
+		File:    c.Pos().File,
+		Line:    c.Pos().Line,
+		Origin:  c.Origin(),
+		Include: c.Include(),
+		Options: []string{},
+	}
+	for _, d := range c.Deps() {
+		e.Deps = append(e.Deps, d.Path())
+	}
+	e.Options = append(e.Options, a.activeOptionScopes(c.Path())...)
+	return e
+}
+
+// hasHiddenParent returns true if a parent command of c is hidden.
+func (a *app) hasHiddenParent(c *cmd.Command) bool {
+	parts := strings.Split(c.Path(), ".")
+	for i := 1; i < len(parts); i++ {
+		p, err := a.commands.Lookup(nil, strings.Join(parts[:i], "."))
+		if err == nil && p.Hidden() {
+			return true
+		}
+	}
+	return false
+}
+
+// tsvField replaces the tabs and newlines of s, which would break the
+// columns and rows of the tsv output, with blanks.
+func tsvField(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,112 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestList pins the fields and their order of the json and tsv output,
+// which tools rely on.
+func TestList(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+options:
+    debug: false
+commands:
+    build:
+        help: "build\tthe\nbinary"
+        alias: [b]
+        group: Dev
+        exec: echo build
+    test:
+        help: test
+        args: [target]
+        deps: [build]
+        exec: echo test
+    tools:
+        help: tools
+        hidden: true
+        commands:
+            lint:
+                help: lint
+                exec: echo lint
+`,
+	})
+
+	const wantJSON = `[
+  {
+    "path": "build",
+    "aliases": [
+      "b"
+    ],
+    "help": "build\tthe\nbinary",
+    "group": "Dev",
+    "args": [],
+    "deps": [],
+    "fanout": "",
+    "file": "grml.yaml",
+    "line": 6,
+    "origin": "",
+    "include": "",
+    "options": [
+      ""
+    ]
+  },
+  {
+    "path": "test",
+    "aliases": [],
+    "help": "test",
+    "group": "",
+    "args": [
+      "target"
+    ],
+    "deps": [
+      "build"
+    ],
+    "fanout": "",
+    "file": "grml.yaml",
+    "line": 11,
+    "origin": "",
+    "include": "",
+    "options": [
+      ""
+    ]
+  }
+]
+`
+	const wantTSV = "build\tb\tbuild the binary\tDev\t\t\t\tgrml.yaml\t6\t\t\t\n" +
+		"test\t\ttest\t\ttarget\tbuild\t\tgrml.yaml\t11\t\t\t\n"
+
+	for format, want := range map[string]string{"json": wantJSON, "tsv": wantTSV} {
+		var out bytes.Buffer
+		if err := a.list(&out, format); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%s output\n%s\nwant\n%s", format, out.String(), want)
+		}
+	}
+
+	if err := a.list(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("no error for an unknown format")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"path/filepath"
+
+	"github.com/desertbit/grml/internal/manifest"
+)
+
+// migrate upgrades the grml file and its include files to the current
+// version and reloads them.
+func (a *app) migrate() error {
+	from, files, warnings, err := manifest.Migrate(a.manifestPath)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Base(a.manifestPath)
+	if from == manifest.Version {
+		a.Printf("%s is up to date (version %d)\n", file, from)
+		return nil
+	}
+	for _, w := range warnings {
+		a.Printf("warning: %s\n", w)
+	}
+	a.Printf("migrated %s from version %d to %d\n", file, from, manifest.Version)
+	for _, f := range files {
+		if f == a.manifestPath {
+			continue
+		}
+		if rel, err := filepath.Rel(filepath.Dir(a.manifestPath), f); err == nil {
+			f = rel
+		}
+		a.Printf("migrated %s\n", f)
+	}
+
+	// Pick up the migrated file in shell sessions.
+	if a.manifest != nil {
+		return a.reload()
+	}
+	return nil
+}
@@ -21,9 +21,11 @@ package app
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/desertbit/columnize"
+	"github.com/desertbit/grml/internal/options"
 	"github.com/desertbit/grumble"
 	"gopkg.in/AlecAivazis/survey.v1"
 )
@@ -42,42 +44,91 @@ func (a *app) attachOptions(addCmd func(cmd *grumble.Command), scopePath string)
 		},
 	}
 
-	cmd.AddCommand(&grumble.Command{
+	addSubCmd := a.addSubCommand(cmd)
+	addSubCmd(&grumble.Command{
 		Name: "check",
 		Help: "select options",
 		Run: func(c *grumble.Context) error {
-			return a.optionsCheck(scopePath)
+			return a.updateOptions(func() error {
+				return a.optionsCheck(scopePath)
+			})
 		},
 	})
 
-	cmd.AddCommand(&grumble.Command{
+	addSubCmd(&grumble.Command{
 		Name: "set",
-		Help: "set a specific choice option",
+		Help: "set a specific option",
 		Args: func(args *grumble.Args) {
 			args.String("option", "name of option")
+			args.String("value", "new value, \"\" to clear a string (prompted if omitted)", grumble.Default(""))
 		},
 		Completer: func(prefix string, args []string) []string {
 			opts := a.options[scopePath]
-			if opts == nil {
+			if opts == nil || len(args) > 0 {
 				return nil
 			}
 			var words []string
-			for name := range opts.Choices {
+			for _, name := range opts.Names() {
 				if strings.HasPrefix(name, prefix) {
 					words = append(words, name)
 				}
 			}
-			sort.Strings(words)
 			return words
 		},
 		Run: func(c *grumble.Context) error {
-			return a.optionsSet(scopePath, c.Args.String("option"))
+			return a.updateOptions(func() error {
+				value := c.Args["value"]
+				return a.optionsSet(scopePath, c.Args.String("option"), value.Value.(string), !value.IsDefault)
+			})
 		},
 	})
 
 	addCmd(cmd)
 }
 
+// updateOptions runs fn, which modifies options, and reverts all its
+// changes if fn fails or they violate an option constraint.
+func (a *app) updateOptions(fn func() error) error {
+	backup := a.cloneOptions()
+	err := fn()
+	if err == nil {
+		err = a.checkOptions()
+	}
+	if err != nil {
+		a.options = backup
+	}
+	return err
+}
+
+// cloneOptions returns a deep copy of the options of all scopes.
+func (a *app) cloneOptions() map[string]*options.Options {
+	c := make(map[string]*options.Options, len(a.options))
+	for sp, o := range a.options {
+		c[sp] = o.Clone()
+	}
+	return c
+}
+
+// checkOptions validates the option constraints of every scope.
+func (a *app) checkOptions() error {
+	scopes := make([]string, 0, len(a.options))
+	for sp := range a.options {
+		scopes = append(scopes, sp)
+	}
+	sort.Strings(scopes)
+
+	for _, sp := range scopes {
+		err := a.options[sp].Check(a.optionsEnv(sp), a.optionsActive(sp))
+		if err != nil {
+			if sp == "" {
+				return err
+			}
+			return fmt.Errorf("command '%s': %v", sp, err)
+		}
+	}
+	return nil
+}
+
 func (a *app) optionsCheck(scopePath string) error {
 	opts := a.options[scopePath]
 	if opts == nil || len(opts.Bools) == 0 {
@@ -85,22 +136,26 @@ func (a *app) optionsCheck(scopePath string) error {
 	}
 
 	names := make([]string, 0, len(opts.Bools))
+	helps := make(map[string]string, len(opts.Bools))
 	var defaults []string
 	for name, o := range opts.Bools {
 		names = append(names, name)
+		helps[name] = o.Help
 		if o.Value {
 			defaults = append(defaults, name)
 		}
 	}
 	sort.Strings(names)
 
+	labels, values := optionLabels(names, helps)
 	var selected []string
 	prompt := &survey.MultiSelect{
 		Message: "Select Options:",
-		Options: names,
-		Default: defaults,
+		Options: labels,
+		Default: toLabels(defaults, labels, values),
 	}
 	survey.AskOne(prompt, &selected, nil)
+	selected = fromLabels(selected, values)
 
 Loop:
 	for _, name := range names {
@@ -115,25 +170,134 @@ Loop:
 	return nil
 }
 
-func (a *app) optionsSet(scopePath, name string) error {
+// optionsSet assigns value to the named option, which may be empty to
+// clear a string option. If no value was given, the user is prompted
+// with a survey matching the option's type.
+func (a *app) optionsSet(scopePath, name, value string, given bool) error {
 	opts := a.options[scopePath]
 	if opts == nil {
 		return fmt.Errorf("no options in scope")
 	}
-	o := opts.Choices[name]
-	if o == nil {
-		return fmt.Errorf("invalid choice option: does not exist")
+	if !opts.Has(name) {
+		return fmt.Errorf("invalid option: does not exist")
+	}
+	if given {
+		return opts.Set(name, value)
+	}
+
+	if b, ok := opts.Bools[name]; ok {
+		prompt := &survey.Confirm{
+			Message: "Enable " + name + ":",
+			Default: b.Value,
+			Help:    b.Help,
+		}
+		return survey.AskOne(prompt, &b.Value, nil)
+	} else if o, ok := opts.Choices[name]; ok {
+		labels, values := optionLabels(o.Options, o.Descriptions)
+		prompt := &survey.Select{
+			Message: "Select Option:",
+			Options: labels,
+			Default: toLabels([]string{o.Active}, labels, values)[0],
+			Help:    o.Help,
+		}
+		var selected string
+		survey.AskOne(prompt, &selected, nil)
+		if selected != "" {
+			o.Active = values[selected]
+		}
+		o.UserSet = true
+		return nil
+	} else if o, ok := opts.Strings[name]; ok {
+		prompt := &survey.Input{
+			Message: "Set " + name + ":",
+			Default: o.Value,
+			Help:    o.Help,
+		}
+		if o.Pattern != nil {
+			prompt.Help = strings.TrimSpace(prompt.Help + " (must match " + o.Pattern.String() + ")")
+		}
+		var v string
+		err := survey.AskOne(prompt, &v, func(ans interface{}) error {
+			return (&options.String{Pattern: o.Pattern}).Set(ans.(string))
+		})
+		if err != nil {
+			return err
+		}
+		return o.Set(v)
+	} else if o, ok := opts.Ints[name]; ok {
+		prompt := &survey.Input{
+			Message: "Set " + name + ":",
+			Default: strconv.Itoa(o.Value),
+			Help:    o.Help,
+		}
+		var v string
+		err := survey.AskOne(prompt, &v, func(ans interface{}) error {
+			i, err := strconv.Atoi(ans.(string))
+			if err != nil {
+				return fmt.Errorf("not an integer")
+			}
+			return (&options.Int{Min: o.Min, Max: o.Max}).Set(i)
+		})
+		if err != nil {
+			return err
+		}
+		return opts.Set(name, v)
 	}
 
-	prompt := &survey.Select{
-		Message: "Select Option:",
-		Options: o.Options,
+	o := opts.Multis[name]
+	labels, values := optionLabels(o.Options, o.Descriptions)
+	prompt := &survey.MultiSelect{
+		Message: "Select Options:",
+		Options: labels,
+		Default: toLabels(o.Active, labels, values),
+		Help:    o.Help,
 	}
-	survey.AskOne(prompt, &o.Active, nil)
+	var selected []string
+	err := survey.AskOne(prompt, &selected, nil)
+	if err != nil {
+		return err
+	}
+	o.Active = fromLabels(selected, values)
 	o.UserSet = true
 	return nil
 }
 
+// optionLabels returns the survey labels for names, each followed by its
+// description if present, and a map from label back to name.
+func optionLabels(names []string, descs map[string]string) (labels []string, values map[string]string) {
+	values = make(map[string]string, len(names))
+	for _, n := range names {
+		label := n
+		if d := descs[n]; d != "" {
+			label = fmt.Sprintf("%s - %s", n, d)
+		}
+		labels = append(labels, label)
+		values[label] = n
+	}
+	return
+}
+
+// toLabels maps names to their labels.
+func toLabels(names, labels []string, values map[string]string) (l []string) {
+	for _, n := range names {
+		for _, label := range labels {
+			if values[label] == n {
+				l = append(l, label)
+				break
+			}
+		}
+	}
+	return
+}
+
+// fromLabels maps labels back to their names.
+func fromLabels(labels []string, values map[string]string) (names []string) {
+	for _, l := range labels {
+		names = append(names, values[l])
+	}
+	return
+}
+
 func (a *app) printOptions(scopePath string) {
 	opts := a.options[scopePath]
 	if opts == nil {
@@ -147,35 +311,101 @@ func (a *app) printOptions(scopePath string) {
 	config.Glue = "  "
 	config.Prefix = "  "
 
-	// Bool options sorted by name.
-	var (
-		output []string
-		keys   []string
-	)
-	for k := range opts.Bools {
-		keys = append(keys, k)
+	bools := make(map[string]string)
+	for k, o := range opts.Bools {
+		bools[k] = strconv.FormatBool(o.Value)
 	}
-	sort.Strings(keys)
-	for _, k := range keys {
-		output = append(output, fmt.Sprintf("%s: | %v", k, opts.Bools[k].Value))
+	choices := make(map[string]string)
+	for k, o := range opts.Choices {
+		choices[k] = o.Active
+	}
+	strs := make(map[string]string)
+	for k, o := range opts.Strings {
+		strs[k] = o.Value
+	}
+	ints := make(map[string]string)
+	for k, o := range opts.Ints {
+		ints[k] = strconv.Itoa(o.Value)
+	}
+	multis := make(map[string]string)
+	for k, o := range opts.Multis {
+		multis[k] = strings.Join(o.Active, ", ")
+	}
+
+	// Mark values deviating from the active profile.
+	for k, pv := range a.profileDeviations(scopePath) {
+		for _, m := range []map[string]string{bools, choices, strs, ints, multis} {
+			if v, ok := m[k]; ok {
+				m[k] = fmt.Sprintf("%s (profile %s: %s)", v, a.profile, pv)
+			}
+		}
+	}
+
+	if a.profile != "" {
+		a.printColor("Profile: ")
+		fmt.Printf("%s\n\n", a.profile)
 	}
-	if len(output) > 0 {
-		a.printColorln("Check Options:\n")
-		fmt.Printf("%s\n\n", columnize.Format(output, config))
+
+	a.printOptionSection("Check Options:", bools, opts, config)
+	a.printOptionSection("Choice Options:", choices, opts, config)
+	a.printOptionSection("String Options:", strs, opts, config)
+	a.printOptionSection("Number Options:", ints, opts, config)
+	a.printOptionSection("Multi Options:", multis, opts, config)
+}
+
+// printOptionSection prints the values sorted by name below title,
+// followed by each option's help text. Nothing is printed for an empty
+// section.
+func (a *app) printOptionSection(title string, values map[string]string, opts *options.Options, config *columnize.Config) {
+	if len(values) == 0 {
+		return
 	}
 
-	// Choice options sorted by name.
-	output = nil
-	keys = nil
-	for k := range opts.Choices {
+	keys := make([]string, 0, len(values))
+	for k := range values {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+
+	output := make([]string, 0, len(keys))
 	for _, k := range keys {
-		output = append(output, fmt.Sprintf("%s: | %v", k, opts.Choices[k].Active))
+		output = append(output, fmt.Sprintf("%s: | %v | %s", k, values[k], opts.Help(k)))
+	}
+	a.printColorln(title + "\n")
+	fmt.Printf("%s\n\n", columnize.Format(output, config))
+}
+
+// setOptionFlags applies '[command.]name=value' assignments.
+func (a *app) setOptionFlags(values []string) error {
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid option flag '%s': expected [command.]name=value", v)
+		}
+		if err := a.setOption(key, value); err != nil {
+			return err
+		}
 	}
-	if len(output) > 0 {
-		a.printColorln("Choice Options:\n")
-		fmt.Printf("%s\n\n", columnize.Format(output, config))
+	return a.checkOptions()
+}
+
+// setOption assigns value to the option addressed by key. The part
+// before the last dot selects the option scope; without one the root
+// scope is used. Constraints are not checked.
+func (a *app) setOption(key, value string) error {
+	scopePath, name := splitOptionKey(key)
+	opts := a.options[scopePath]
+	if opts == nil {
+		return fmt.Errorf("option '%s': no options in scope '%s'", key, scopePath)
+	}
+	return opts.Set(name, value)
+}
+
+// splitOptionKey splits '[command.]name' into the scope path and the
+// option name.
+func splitOptionKey(key string) (scopePath, name string) {
+	if pos := strings.LastIndex(key, "."); pos >= 0 {
+		return key[:pos], key[pos+1:]
 	}
+	return "", key
 }
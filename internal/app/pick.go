@@ -0,0 +1,256 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grumble"
+	"github.com/desertbit/readline"
+	"gopkg.in/AlecAivazis/survey.v1"
+)
+
+// pickKey opens the command picker in the shell (Ctrl-T).
+const pickKey = readline.CharTranspose
+
+// pickKeys forwards the keys to the picker opened by the pick key.
+// readline only stops reading the terminal after an enter key, so it
+// keeps reading the keys meant for the picker and passes them on.
+var pickKeys = &keyForwarder{keys: make(chan rune, 128)}
+
+// attachPick registers the 'pick' builtin under addCmd.
+func (a *app) attachPick(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name:     "pick",
+		Help:     "fuzzy find a command and run it (Ctrl-T)",
+		LongHelp: "Opens a fuzzy finder over all command paths and help texts. Type to filter, select with the arrow keys and enter. The args of the selected command are prompted for with tab completion before it runs. In the shell, Ctrl-T opens the picker, too.",
+		Run: func(c *grumble.Context) error {
+			return a.pick()
+		},
+	})
+}
+
+// pick lets the user select a visible command, prompts for its args and
+// runs it.
+func (a *app) pick() error {
+	var cmds []*cmd.Command
+	a.commands.Walk(func(c *cmd.Command) {
+		if !c.Hidden() && !a.hasHiddenParent(c) {
+			cmds = append(cmds, c)
+		}
+	})
+	if len(cmds) == 0 {
+		return fmt.Errorf("no commands to pick from")
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Path() < cmds[j].Path()
+	})
+
+	labels, picked := a.pickLabels(cmds)
+
+	prompt := &survey.Select{
+		Message:  "Pick a command:",
+		Options:  labels,
+		PageSize: 15,
+		FilterFn: fuzzyFilter,
+	}
+	var opts []survey.AskOpt
+	if pickKeys.active.Load() {
+		defer pickKeys.active.Store(false)
+		opts = append(opts, survey.WithStdio(pickKeys, os.Stdout, os.Stderr))
+	}
+	var selected string
+	err := survey.AskOne(prompt, &selected, nil, opts...)
+	pickKeys.active.Store(false)
+	if err != nil {
+		return err
+	}
+	c := picked[selected]
+	if c == nil {
+		return nil
+	}
+
+	var args map[string]string
+	if c.HasArgs() {
+		args = make(map[string]string)
+		for _, arg := range c.Args() {
+			v, err := a.promptArg(c, arg)
+			if err != nil {
+				return err
+			}
+			args[arg] = v
+		}
+	}
+	return a.exec(c, args)
+}
+
+// pickLabels labels each command with its aligned help, like 'help' does,
+// and maps the labels back to the commands. Only the first line of a
+// multi-line help is shown.
+func (a *app) pickLabels(cmds []*cmd.Command) ([]string, map[string]*cmd.Command) {
+	width := 0
+	for _, c := range cmds {
+		width = max(width, utf8.RuneCountInString(c.Path()))
+	}
+
+	labels := make([]string, 0, len(cmds))
+	picked := make(map[string]*cmd.Command, len(cmds))
+	for _, c := range cmds {
+		help, _, _ := strings.Cut(strings.TrimSpace(a.evalVar(a.cmdEnv(c), c.Help())), "\n")
+		label := strings.TrimSpace(fmt.Sprintf("%-*s  %s", width, c.Path(), help))
+		labels = append(labels, label)
+		picked[label] = c
+	}
+	return labels, picked
+}
+
+// promptArg reads the value of the arg of c with the shell's readline,
+// completing paths like the command's arg completion.
+func (a *app) promptArg(c *cmd.Command, arg string) (string, error) {
+	conf := a.rl.Config
+	prompt, complete, filter := conf.Prompt, conf.AutoComplete, conf.FuncFilterInputRune
+	defer func() {
+		a.rl.SetPrompt(prompt)
+		conf.AutoComplete, conf.FuncFilterInputRune = complete, filter
+	}()
+
+	a.rl.SetPrompt(fmt.Sprintf("%s %s: ", c.Path(), arg))
+	conf.AutoComplete = &pathCompleter{base: a.completeBase(c)}
+	conf.FuncFilterInputRune = nil
+
+	v, err := a.rl.Readline()
+	if err != nil {
+		return "", err
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", fmt.Errorf("missing value for arg '%s'", arg)
+	}
+	return v, nil
+}
+
+// filterInputRune binds the pick key in the shell: it replaces the line
+// with 'pick' and submits it. The following keys are forwarded to the
+// picker until it's closed.
+func (a *app) filterInputRune(r rune) (rune, bool) {
+	if pickKeys.active.Load() {
+		select {
+		case pickKeys.keys <- r:
+		default: // Drop keys the picker doesn't read.
+		}
+		return r, false
+	}
+	if r == pickKey && a.Commands().Get("pick") != nil {
+		pickKeys.active.Store(true)
+		a.rl.Operation.SetBuffer("pick")
+		return readline.CharEnter, true
+	}
+	return r, true
+}
+
+// keyForwarder is a survey input reading the keys forwarded while it's
+// active. Its Fd is the terminal's, so survey can switch it to raw mode.
+type keyForwarder struct {
+	active atomic.Bool
+	keys   chan rune
+}
+
+func (k *keyForwarder) Read(p []byte) (int, error) {
+	r := <-k.keys
+	if len(p) < utf8.RuneLen(r) {
+		return 0, nil
+	}
+	return utf8.EncodeRune(p, r), nil
+}
+
+func (k *keyForwarder) Fd() uintptr {
+	return os.Stdin.Fd()
+}
+
+// pathCompleter completes the whole line as a path relative to base.
+type pathCompleter struct {
+	base string
+}
+
+func (p *pathCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	prefix := string(line[:pos])
+	var suggestions [][]rune
+	for _, m := range completePath(prefix, p.base) {
+		suggestions = append(suggestions, []rune(strings.TrimPrefix(m, prefix)))
+	}
+	return suggestions, len([]rune(prefix))
+}
+
+// fuzzyFilter returns the options containing the characters of filter in
+// order, ignoring case and blanks. Options with closer matches come first.
+func fuzzyFilter(filter string, options []string) []string {
+	type match struct {
+		option string
+		score  int
+	}
+	var matches []match
+	for _, o := range options {
+		if score, ok := fuzzyScore(filter, o); ok {
+			matches = append(matches, match{option: o, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+
+	filtered := make([]string, len(matches))
+	for i, m := range matches {
+		filtered[i] = m.option
+	}
+	return filtered
+}
+
+// fuzzyScore returns whether s contains the characters of pattern in
+// order and the number of characters skipped between the first and last
+// matched character. Lower scores are closer matches.
+func fuzzyScore(pattern, s string) (score int, ok bool) {
+	rs := []rune(strings.ToLower(s))
+	i, first := 0, -1
+	for _, p := range strings.ToLower(pattern) {
+		if unicode.IsSpace(p) {
+			continue
+		}
+		for i < len(rs) && rs[i] != p {
+			if first >= 0 {
+				score++
+			}
+			i++
+		}
+		if i == len(rs) {
+			return 0, false
+		}
+		if first < 0 {
+			first = i
+		}
+		i++
+	}
+	return score, true
+}
@@ -0,0 +1,72 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"testing"
+
+	"github.com/desertbit/grml/internal/cmd"
+)
+
+func TestPickLabels(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+commands:
+    build:
+        help: |
+            build the binary
+            with all its assets
+        exec: echo build
+        commands:
+            docs:
+                help: build the docs
+                exec: echo docs
+    test:
+        help: test
+        exec: echo test
+`,
+	})
+	var cmds []*cmd.Command
+	for _, path := range []string{"build", "build.docs", "test"} {
+		c, err := a.commands.Lookup(nil, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, c)
+	}
+
+	labels, picked := a.pickLabels(cmds)
+	want := []string{
+		"build       build the binary",
+		"build.docs  build the docs",
+		"test        test",
+	}
+	if len(labels) != len(want) {
+		t.Fatalf("labels %q", labels)
+	}
+	for i, l := range labels {
+		if l != want[i] {
+			t.Errorf("label %q, want %q", l, want[i])
+		}
+		if picked[l] != cmds[i] {
+			t.Errorf("label %q picks %v", l, picked[l])
+		}
+	}
+}
@@ -19,9 +19,22 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/desertbit/columnize"
 	"github.com/fatih/color"
 )
 
+var (
+	okColor   = color.New(color.FgGreen, color.Bold)
+	failColor = color.New(color.FgRed, color.Bold)
+)
+
 func (a *app) setNoColor(b bool) {
 	color.NoColor = b
 }
@@ -44,3 +57,82 @@ func (a *app) printColor(s string) {
 func (a *app) printColorln(s string) {
 	a.printColor(s + "\n")
 }
+
+// fprintColorln writes s in the foreground color to w, e.g. the stdout
+// of an exec context.
+func (a *app) fprintColorln(w io.Writer, s string) {
+	a.fgColor.Fprintln(w, s)
+}
+
+// printStep reports the outcome of a step to w, e.g. 'ok build (12.3s)'
+// or 'FAIL tests exit 2 (4.1s)'.
+func (a *app) printStep(w io.Writer, s step) {
+	if s.err == nil {
+		fmt.Fprintf(w, "%s %s (%s)\n", okColor.Sprint("ok"), s.path, formatDuration(s.duration))
+		return
+	}
+	if status := exitText(s.err); status != "" {
+		fmt.Fprintf(w, "%s %s %s (%s)\n", failColor.Sprint("FAIL"), s.path, status, formatDuration(s.duration))
+		return
+	}
+	fmt.Fprintf(w, "%s %s (%s)\n", failColor.Sprint("FAIL"), s.path, formatDuration(s.duration))
+}
+
+// printSummary prints the steps of a run and its total duration to w.
+// The status column is padded before it's colored, because columnize
+// would count the color codes.
+func (a *app) printSummary(w io.Writer, steps []step, total time.Duration) {
+	statuses := make([]string, len(steps))
+	lines := make([]string, 0, len(steps)+1)
+	width := 0
+	for i, s := range steps {
+		switch {
+		case s.skipped:
+			statuses[i] = "skipped"
+			lines = append(lines, s.path)
+		case s.err == nil:
+			statuses[i] = "ok"
+			lines = append(lines, fmt.Sprintf("%s | %s", s.path, formatDuration(s.duration)))
+		default:
+			statuses[i] = "FAIL"
+			lines = append(lines, fmt.Sprintf("%s | %s | %s", s.path, formatDuration(s.duration), exitText(s.err)))
+		}
+		width = max(width, len(statuses[i]))
+	}
+	lines = append(lines, fmt.Sprintf("total | %s", formatDuration(total)))
+
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+
+	fmt.Fprintln(w)
+	a.fprintColorln(w, "Summary:")
+	for i, row := range strings.Split(columnize.Format(lines, config), "\n") {
+		status := strings.Repeat(" ", width)
+		if i < len(steps) {
+			status = fmt.Sprintf("%-*s", width, statuses[i])
+			switch statuses[i] {
+			case "ok":
+				status = okColor.Sprint(status)
+			case "FAIL":
+				status = failColor.Sprint(status)
+			}
+		}
+		fmt.Fprintf(w, "  %s  %s\n", status, row)
+	}
+}
+
+// exitText returns 'exit <code>' if err is the exit of a shell command
+// or an empty string otherwise.
+func exitText(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	return fmt.Sprintf("exit %d", exitStatus(err))
+}
+
+// formatDuration formats d in seconds with one decimal, e.g. '12.3s'.
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
@@ -0,0 +1,120 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	for _, tc := range []struct {
+		err    error
+		status int
+		text   string
+	}{
+		{nil, 0, ""},
+		{exitErr, 3, "exit 3"},
+		{errors.New("hook failed"), 1, ""},
+	} {
+		if s := exitStatus(tc.err); s != tc.status {
+			t.Errorf("exitStatus(%v) = %d, want %d", tc.err, s, tc.status)
+		}
+		if s := exitText(tc.err); s != tc.text {
+			t.Errorf("exitText(%v) = %q, want %q", tc.err, s, tc.text)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	a := newTestApp(t, map[string]string{"grml.yaml": "version: 3\nproject: p\n"})
+	exitErr := exec.Command("sh", "-c", "exit 2").Run()
+
+	var out bytes.Buffer
+	a.printSummary(&out, []step{
+		{path: "gen", duration: 1200 * time.Millisecond},
+		{path: "lint", duration: 300 * time.Millisecond, err: exitErr},
+		{path: "build", skipped: true},
+	}, 1500*time.Millisecond)
+
+	want := "\nSummary:\n" +
+		"  ok       gen    1.2s\n" +
+		"  FAIL     lint   0.3s  exit 2\n" +
+		"  skipped  build\n" +
+		"           total  1.5s\n"
+	if out.String() != want {
+		t.Errorf("summary\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestExecSummary(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+commands:
+    gen:
+        help: gen
+        exec: echo gen
+    style:
+        help: style
+        deps: [gen]
+        exec: exit 2
+    vet:
+        help: vet
+        exec: echo vet
+    build:
+        help: build
+        deps: [style, vet]
+        exec: echo build
+`,
+	})
+	c, err := a.commands.Lookup(nil, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := a.execRun(newTestContext(&out), c, nil); exitStatus(err) != 2 {
+		t.Fatalf("error %v", err)
+	}
+	want := regexp.MustCompile(`\nSummary:\n` +
+		`  ok       gen    \d+\.\ds\n` +
+		`  FAIL     style  \d+\.\ds  exit 2\n` +
+		`  skipped  vet\s*\n` +
+		`  skipped  build\s*\n` +
+		`           total  \d+\.\ds\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("summary:\n%s", out.String())
+	}
+
+	// A command without deps has no summary.
+	out.Reset()
+	c, _ = a.commands.Lookup(nil, "vet")
+	if err := a.execRun(newTestContext(&out), c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), []byte("Summary:")) {
+		t.Errorf("summary without deps:\n%s", out.String())
+	}
+}
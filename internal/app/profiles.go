@@ -0,0 +1,178 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/grumble"
+)
+
+// attachProfile registers the 'profile' builtin under addCmd.
+func (a *app) attachProfile(addCmd func(cmd *grumble.Command)) {
+	addCmd(&grumble.Command{
+		Name: "profile",
+		Help: "list or apply option profiles",
+		Args: func(args *grumble.Args) {
+			args.String("name", "name of the profile to apply", grumble.Default(""))
+		},
+		Completer: func(prefix string, args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			var words []string
+			for _, name := range a.profileNames() {
+				if strings.HasPrefix(name, prefix) {
+					words = append(words, name)
+				}
+			}
+			return words
+		},
+		Run: func(c *grumble.Context) error {
+			name := c.Args.String("name")
+			if name == "" {
+				a.printProfiles()
+				return nil
+			}
+			err := a.setProfile(name)
+			if err != nil {
+				return err
+			}
+			a.Printf("applied profile %s\n", name)
+			return nil
+		},
+	})
+}
+
+// setProfile applies the named profile and makes it the active one.
+// All changes are reverted if the resulting options are invalid.
+func (a *app) setProfile(name string) error {
+	err := a.updateOptions(func() error {
+		return a.applyProfile(name)
+	})
+	if err != nil {
+		return err
+	}
+	a.profile = name
+	return nil
+}
+
+// applyProfile assigns all option values of the named profile.
+// Constraints are not checked.
+func (a *app) applyProfile(name string) error {
+	p, ok := a.manifest.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile '%s': does not exist", name)
+	}
+
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := a.setOption(k, profileValue(p[k]))
+		if err != nil {
+			return a.manifest.PosOf("profiles", name).Errorf("profile '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// checkProfiles ensures every profile applies cleanly on top of the
+// current options, which are left untouched.
+func (a *app) checkProfiles() error {
+	for _, name := range a.profileNames() {
+		backup := a.cloneOptions()
+		err := a.applyProfile(name)
+		if err == nil {
+			err = a.checkOptions()
+			if err != nil {
+				err = a.manifest.PosOf("profiles", name).Errorf("profile '%s': %v", name, err)
+			}
+		}
+		a.options = backup
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileDeviations returns the options at scopePath whose current value
+// differs from the active profile, mapped to the profile's value.
+func (a *app) profileDeviations(scopePath string) map[string]string {
+	dev := make(map[string]string)
+	opts := a.options[scopePath]
+	if a.profile == "" || opts == nil {
+		return dev
+	}
+
+	env := opts.Env()
+	for k, v := range a.manifest.Profiles[a.profile] {
+		sp, name := splitOptionKey(k)
+		if sp != scopePath {
+			continue
+		}
+
+		// Compare the exported values, so e.g. '1' and 'true' are equal.
+		pv := profileValue(v)
+		c := opts.Clone()
+		if c.Set(name, pv) == nil && c.Env()[name] != env[name] {
+			dev[name] = pv
+		}
+	}
+	return dev
+}
+
+func (a *app) printProfiles() {
+	for _, name := range a.profileNames() {
+		if name == a.profile {
+			a.printColorln("* " + name)
+		} else {
+			a.Println("  " + name)
+		}
+	}
+}
+
+// profileNames returns the sorted names of all profiles.
+func (a *app) profileNames() []string {
+	names := make([]string, 0, len(a.manifest.Profiles))
+	for name := range a.manifest.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileValue formats a profile value for options.Options.Set.
+// Lists assign multi-select options.
+func profileValue(v interface{}) string {
+	if l, ok := v.([]interface{}); ok {
+		s := make([]string, len(l))
+		for i, iv := range l {
+			s[i] = fmt.Sprintf("%v", iv)
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
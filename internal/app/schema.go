@@ -0,0 +1,39 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+
+	"github.com/desertbit/grml/internal/manifest"
+)
+
+// schema prints the JSON Schema of the grml file or of an include file.
+func (a *app) schema(kind string) error {
+	if kind != "manifest" && kind != "include" {
+		return fmt.Errorf("invalid schema kind '%s': expected 'manifest' or 'include'", kind)
+	}
+
+	data, err := manifest.Schema(kind == "include")
+	if err != nil {
+		return err
+	}
+	a.Println(string(data))
+	return nil
+}
@@ -0,0 +1,247 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/columnize"
+	"github.com/desertbit/grumble"
+	"github.com/desertbit/readline"
+	"github.com/fatih/color"
+)
+
+// addSubCommand returns a func that adds a sub command to parent and
+// records it for the help.
+func (a *app) addSubCommand(parent *grumble.Command) func(sub *grumble.Command) {
+	return func(sub *grumble.Command) {
+		a.subs[parent] = append(a.subs[parent], sub)
+		parent.AddCommand(sub)
+	}
+}
+
+// visibleCommands returns the commands of cs that are listed in the help,
+// sorted by name: all but the hidden ones and the '__complete' builtin.
+func (a *app) visibleCommands(cs []*grumble.Command) []*grumble.Command {
+	var visible []*grumble.Command
+	for _, c := range cs {
+		if a.hidden[c] || c.Name == completeCommand {
+			continue
+		}
+		visible = append(visible, c)
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		return visible[i].Name < visible[j].Name
+	})
+	return visible
+}
+
+// commandLines returns the help lines of cs for columnize.
+func commandLines(cs []*grumble.Command) []string {
+	lines := make([]string, 0, len(cs))
+	for _, c := range cs {
+		name := c.Name
+		for _, alias := range c.Aliases {
+			name += ", " + alias
+		}
+		lines = append(lines, fmt.Sprintf("%s | %v", name, c.Help))
+	}
+	return lines
+}
+
+// printHelp prints the help like grumble's default, but without the
+// hidden commands. grumble has no notion of hidden commands.
+func (a *app) printHelp(gapp *grumble.App, shell bool) {
+	conf := a.Config()
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = "  "
+	config.Prefix = "  "
+
+	if !conf.NoColor {
+		conf.ASCIILogoColor.Set()
+	}
+	a.printGRML()
+	if !conf.NoColor {
+		color.Unset()
+	}
+	a.Printf("\n%s\n", conf.Description)
+
+	if !shell {
+		a.Printf("\n%s\n", a.headline("Usage:"))
+		a.Printf("  %s [command]\n", conf.Name)
+	}
+
+	// Group the commands by their help group.
+	visible := a.visibleCommands(a.Commands().All())
+	groups := make(map[string][]*grumble.Command)
+	for _, c := range visible {
+		group := c.HelpGroup
+		if group == "" {
+			group = "Commands:"
+		}
+		groups[group] = append(groups[group], c)
+	}
+	headlines := make([]string, 0, len(groups))
+	for group := range groups {
+		headlines = append(headlines, group)
+	}
+	sort.Strings(headlines)
+	for _, group := range headlines {
+		a.Printf("\n%s\n", a.headline(group))
+		a.Printf("%s\n", columnize.Format(commandLines(groups[group]), config))
+	}
+
+	// Only the first level of sub commands.
+	var subHelp []string
+	for _, c := range visible {
+		subs := a.visibleCommands(a.subs[c])
+		if len(subs) == 0 {
+			continue
+		}
+		name := c.Name + ":"
+		if !conf.NoColor && conf.HelpHeadlineColor != nil {
+			name = conf.HelpHeadlineColor.Sprint(name)
+		}
+		subHelp = append(subHelp, fmt.Sprintf("\n%s\n%s\n", name, columnize.Format(commandLines(subs), config)))
+	}
+	if len(subHelp) > 0 {
+		a.Printf("\n%s\n", a.headline("Sub Commands:"))
+		a.Print(strings.Join(subHelp, ""))
+	}
+
+	if !shell {
+		a.printFlags()
+	}
+	a.Println()
+}
+
+// printFlags prints the global flags like grumble's help.
+func (a *app) printFlags() {
+	config := columnize.DefaultConfig()
+	config.Delim = "|"
+	config.Glue = " "
+	config.Prefix = "  "
+
+	flags := append(append([]globalFlag{}, globalFlags...), builtinFlags...)
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].long < flags[j].long
+	})
+
+	lines := make([]string, 0, len(flags))
+	for _, f := range flags {
+		short := ""
+		if f.short != "" {
+			short = "-" + f.short + ","
+		}
+		var kind, def string
+		switch v := f.def.(type) {
+		case string:
+			kind = "string"
+			if v != "" {
+				def = fmt.Sprintf("(default: %v)", v)
+			}
+		case bool:
+			kind = "bool"
+		case []string:
+			kind = "stringList"
+			def = fmt.Sprintf("(default: %v)", v)
+		}
+		lines = append(lines, fmt.Sprintf("%s | --%s | %s |||| %s %s", short, f.long, kind, f.help, def))
+	}
+
+	a.Printf("\n%s\n", a.headline("Flags:"))
+	a.Printf("%s\n", columnize.Format(lines, config))
+}
+
+// printCommandHelp prints the help of gc with grumble's default printer,
+// but without the hidden sub commands. The printer is unexported, so a
+// copy of gc with its visible sub commands is printed by a scratch app.
+// The help of a hidden command itself is still available.
+func (a *app) printCommandHelp(gapp *grumble.App, gc *grumble.Command, shell bool) {
+	conf := a.Config()
+	scratch := grumble.New(&grumble.Config{
+		Name:                  conf.Name,
+		NoColor:               conf.NoColor,
+		HelpHeadlineColor:     conf.HelpHeadlineColor,
+		HelpHeadlineUnderline: conf.HelpHeadlineUnderline,
+	})
+
+	c := &grumble.Command{
+		Name:      gc.Name,
+		Aliases:   gc.Aliases,
+		Help:      gc.Help,
+		LongHelp:  gc.LongHelp,
+		HelpGroup: gc.HelpGroup,
+		Usage:     gc.Usage,
+		Flags:     gc.Flags,
+		Args:      gc.Args,
+	}
+	for _, sub := range a.visibleCommands(a.subs[gc]) {
+		c.AddCommand(&grumble.Command{
+			Name:    sub.Name,
+			Aliases: sub.Aliases,
+			Help:    sub.Help,
+		})
+	}
+	scratch.AddCommand(c)
+
+	if err := scratch.RunCommand([]string{c.Name, "--help"}); err != nil {
+		a.PrintError(err)
+	}
+}
+
+// visibleCompleter removes the hidden commands from the suggestions of
+// the shell's completer.
+type visibleCompleter struct {
+	a    *app
+	next readline.AutoCompleter
+}
+
+func (v *visibleCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	suggestions, length := v.next.Do(line, pos)
+
+	// Split the line like grumble's completer.
+	words := strings.Fields(string(line[:pos]))
+	prefix := ""
+	if len(words) > 0 && line[pos-1] != ' ' {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	if len(words) > 0 && words[0] == "help" {
+		words = words[1:]
+	}
+
+	visible := suggestions[:0]
+	for _, s := range suggestions {
+		name := prefix + strings.TrimSuffix(string(s), " ")
+		if len(words) == 0 && name == completeCommand {
+			continue
+		}
+		path := append(append([]string{}, words...), name)
+		gc, rest, err := v.a.Commands().FindCommand(path)
+		if err == nil && len(rest) == 0 && v.a.hidden[gc] {
+			continue
+		}
+		visible = append(visible, s)
+	}
+	return visible, length
+}
@@ -0,0 +1,73 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package app
+
+import (
+	"testing"
+
+	"github.com/desertbit/grumble"
+)
+
+func TestVisibleCommands(t *testing.T) {
+	a := newTestApp(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+commands:
+    build:
+        help: build
+        exec: echo build
+        commands:
+            debug:
+                help: debug build
+                hidden: true
+                exec: echo debug
+            race:
+                help: race build
+                exec: echo race
+    clean:
+        help: clean
+        hidden: true
+        exec: echo clean
+    setup:
+        help: setup
+        internal: true
+        exec: echo setup
+`,
+	})
+	a.AddCommand(a.newCompleteCommand())
+
+	names := func(cs []*grumble.Command) (n []string) {
+		for _, c := range cs {
+			n = append(n, c.Name)
+		}
+		return
+	}
+	build := a.Commands().Get("build")
+	if build == nil || a.Commands().Get("clean") == nil {
+		t.Fatal("hidden commands are not registered")
+	}
+	for _, n := range names(a.visibleCommands(a.Commands().All())) {
+		if n == "clean" || n == "setup" || n == completeCommand {
+			t.Errorf("%s is visible", n)
+		}
+	}
+	if got := names(a.visibleCommands(a.subs[build])); len(got) != 1 || got[0] != "race" {
+		t.Errorf("visible sub commands %v", got)
+	}
+}
@@ -20,23 +20,32 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/desertbit/grml/internal/manifest"
-	"gopkg.in/yaml.v2"
 )
 
 type Commands []*Command
 
+// HookScope are the hooks declared by the manifest or a command.
+type HookScope struct {
+	Path  string // of the declaring command, "" for the manifest
+	Hooks *manifest.Hooks
+}
+
 type Command struct {
-	name    string
-	path    string
-	origin  string // path of the nearest enclosing 'include' point; "" for root-level commands
-	mc      *manifest.Command
-	envs    []yaml.MapSlice // ordered scope chain from outermost ancestor to self
-	imports []string        // ordered: ancestors' imports first, command's own last
-	cmds    Commands
-	deps    Commands
+	name      string
+	path      string
+	origin    string // path of the nearest enclosing 'include' point; "" for root-level commands
+	mc        *manifest.Command
+	envs      []manifest.Env    // ordered scope chain from outermost ancestor to self
+	imports   []string          // ordered: ancestors' imports first, command's own last
+	hooks     []HookScope       // ordered scope chain from outermost ancestor to self
+	functions map[string]string // of the manifest and all ancestors, inner ones replacing outer
+	cmds      Commands
+	deps      Commands
+	fanout    Commands
 }
 
 // Name returns the command's name.
@@ -57,6 +66,46 @@ func (c *Command) Help() string {
 	return c.mc.Help
 }
 
+// Description returns the command's long help. Defaults to the help.
+func (c *Command) Description() string {
+	if c.mc.Description == "" {
+		return c.mc.Help
+	}
+	return c.mc.Description
+}
+
+// Group returns the help section of the command or an empty string.
+func (c *Command) Group() string {
+	return c.mc.Group
+}
+
+// Pos returns where the command was declared.
+func (c *Command) Pos() manifest.Pos {
+	return c.mc.Pos
+}
+
+// PosOf returns where the field at the keys of the command was declared,
+// e.g. PosOf("alias", 1).
+func (c *Command) PosOf(keys ...interface{}) manifest.Pos {
+	return c.mc.PosOf(keys...)
+}
+
+// Include returns the file included by the command or an empty string.
+func (c *Command) Include() string {
+	return c.mc.Include
+}
+
+// Hidden returns true if the command is omitted from help and completion.
+// Internal commands are always hidden.
+func (c *Command) Hidden() bool {
+	return c.mc.Hidden || c.mc.Internal
+}
+
+// Internal returns true if the command may only run as a dependency.
+func (c *Command) Internal() bool {
+	return c.mc.Internal
+}
+
 func (c *Command) HasArgs() bool {
 	return len(c.mc.Args) > 0
 }
@@ -81,13 +130,70 @@ func (c *Command) Deps() Commands {
 	return c.deps
 }
 
+// Origin returns the path of the nearest enclosing include point or an
+// empty string for commands of the root manifest.
+func (c *Command) Origin() string {
+	return c.origin
+}
+
+// IsInclude returns true if the command is an include point.
+func (c *Command) IsInclude() bool {
+	return c.mc.Include != ""
+}
+
+// Fanout returns the commands run by the command's 'fanout' declaration
+// and whether they run in parallel.
+func (c *Command) Fanout() (cmds Commands, parallel bool) {
+	if c.mc.Fanout == nil {
+		return nil, false
+	}
+	return c.fanout, c.mc.Fanout.Parallel
+}
+
+// HasFanout returns true if the command declares a fanout.
+func (c *Command) HasFanout() bool {
+	return c.mc.Fanout != nil
+}
+
+// DepPaths returns the dependency paths as declared in the manifest.
+func (c *Command) DepPaths() []string {
+	return c.mc.Deps
+}
+
+// FanoutPath returns the fanout command path as declared in the manifest
+// or an empty string.
+func (c *Command) FanoutPath() string {
+	if c.mc.Fanout == nil {
+		return ""
+	}
+	return c.mc.Fanout.Command
+}
+
 // Envs returns the ordered scope chain that applies to this command,
 // from the outermost ancestor scope down to the command's own scope.
 // Empty if no ancestor or this command declared an 'env:' section.
-func (c *Command) Envs() []yaml.MapSlice {
+func (c *Command) Envs() []manifest.Env {
 	return c.envs
 }
 
+// Hooks returns the ordered hook scope chain that applies to this
+// command, from the manifest down to the command's own scope.
+func (c *Command) Hooks() []HookScope {
+	return c.hooks
+}
+
+// Functions returns the shell functions that apply to this command by
+// name. Functions of inner scopes replace those of outer ones.
+func (c *Command) Functions() map[string]string {
+	return c.functions
+}
+
+// DeclaredFunctions returns the shell functions declared by the command
+// itself.
+func (c *Command) DeclaredFunctions() map[string]string {
+	return c.mc.Functions
+}
+
 // Imports returns the ordered list of per-include import paths (root-relative)
 // that apply to this command, ancestors first, command's own last.
 func (c *Command) Imports() []string {
@@ -95,22 +201,97 @@ func (c *Command) Imports() []string {
 }
 
 func ParseManifest(m *manifest.Manifest) (cmds Commands, err error) {
-	cmds = make(Commands, 0, m.Commands.Count())
-
-	// Add the commands from the manifest.
-	addCommands("", "", nil, nil, &cmds, m.Commands)
+	cmds, err = Build(m)
+	if err != nil {
+		return
+	}
 
 	// Link the dependencies now.
 	err = linkDeps(cmds, cmds)
 	return
 }
 
-func addCommands(parentPath, parentOrigin string, parentEnvs []yaml.MapSlice, parentImports []string, cmds *Commands, mcs manifest.Commands) {
+// Build returns the command tree of the manifest without linking the
+// dependencies. Matrix commands are expanded into their variants.
+func Build(m *manifest.Manifest) (Commands, error) {
+	var hooks []HookScope
+	if m.Hooks != nil {
+		hooks = []HookScope{{Hooks: m.Hooks}}
+	}
+
+	cmds := make(Commands, 0, m.Commands.Count())
+	err := addCommands("", "", nil, nil, hooks, m.Functions, &cmds, m.Commands)
+	return cmds, err
+}
+
+// Lookup returns the command addressed by path. Relative paths ('.' and
+// '~.' prefixes) are resolved from the command from, which may be nil
+// for absolute paths.
+func (cs Commands) Lookup(from *Command, path string) (*Command, error) {
+	if from == nil && strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("invalid path '%s': relative to nothing", path)
+	} else if from == nil && strings.HasPrefix(path, "~") {
+		from = &Command{}
+	}
+	return getCommandByPath(cs, from, path)
+}
+
+// IncludeTargets returns the command at path below every include point
+// in the scope of from. The scope is the subtree of from's include point
+// or the whole tree if from is nil or not part of an include. from itself
+// is never returned.
+func (cs Commands) IncludeTargets(from *Command, path string) (targets Commands) {
+	scope := cs
+	if from != nil && from.origin != "" {
+		o, err := getCommandByPath(cs, from, from.origin)
+		if err != nil {
+			return nil
+		}
+		scope = o.cmds
+	}
+
+	scope.Walk(func(c *Command) {
+		if !c.IsInclude() {
+			return
+		}
+		t, err := getCommandByPath(cs, c, "."+path)
+		if err == nil && t != from {
+			targets = append(targets, t)
+		}
+	})
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].path < targets[j].path
+	})
+	return
+}
+
+// Walk calls fn for every command and all of its sub commands, parents
+// first.
+func (cs Commands) Walk(fn func(c *Command)) {
+	for _, c := range cs {
+		fn(c)
+		c.cmds.Walk(fn)
+	}
+}
+
+func addCommands(parentPath, parentOrigin string, parentEnvs []manifest.Env, parentImports []string, parentHooks []HookScope, parentFunctions map[string]string, cmds *Commands, mcs manifest.Commands) error {
 	for name, mc := range mcs {
+		var path string
+		if len(parentPath) == 0 {
+			path = name
+		} else {
+			path = parentPath + "." + name
+		}
+
+		mc, err := mc.ExpandMatrix(path)
+		if err != nil {
+			return err
+		}
+
 		// Extend the parent's scope chain when this command declares its own env.
 		envs := parentEnvs
 		if len(mc.Env) > 0 {
-			envs = make([]yaml.MapSlice, 0, len(parentEnvs)+1)
+			envs = make([]manifest.Env, 0, len(parentEnvs)+1)
 			envs = append(envs, parentEnvs...)
 			envs = append(envs, mc.Env)
 		}
@@ -123,11 +304,24 @@ func addCommands(parentPath, parentOrigin string, parentEnvs []yaml.MapSlice, pa
 			imports = append(imports, mc.Import...)
 		}
 
-		var path string
-		if len(parentPath) == 0 {
-			path = name
-		} else {
-			path = parentPath + "." + name
+		// Extend the parent's hook chain when this command declares its own hooks.
+		hooks := parentHooks
+		if mc.Hooks != nil {
+			hooks = make([]HookScope, 0, len(parentHooks)+1)
+			hooks = append(hooks, parentHooks...)
+			hooks = append(hooks, HookScope{Path: path, Hooks: mc.Hooks})
+		}
+
+		// Replace the parent's functions by name when this command declares its own.
+		functions := parentFunctions
+		if len(mc.Functions) > 0 {
+			functions = make(map[string]string, len(parentFunctions)+len(mc.Functions))
+			for k, v := range parentFunctions {
+				functions[k] = v
+			}
+			for k, v := range mc.Functions {
+				functions[k] = v
+			}
 		}
 
 		// If this command brings in an included subgrml file, it becomes
@@ -138,43 +332,64 @@ func addCommands(parentPath, parentOrigin string, parentEnvs []yaml.MapSlice, pa
 		}
 
 		c := &Command{
-			name:    name,
-			path:    path,
-			origin:  origin,
-			mc:      mc,
-			envs:    envs,
-			imports: imports,
-			cmds:    make(Commands, 0, mc.Commands.Count()),
+			name:      name,
+			path:      path,
+			origin:    origin,
+			mc:        mc,
+			envs:      envs,
+			imports:   imports,
+			hooks:     hooks,
+			functions: functions,
+			cmds:      make(Commands, 0, mc.Commands.Count()),
 		}
 		*cmds = append(*cmds, c)
 
 		// Add sub commands.
-		addCommands(path, origin, envs, imports, &c.cmds, mc.Commands)
+		err = addCommands(path, origin, envs, imports, hooks, functions, &c.cmds, mc.Commands)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func linkDeps(root, cmds Commands) (err error) {
 	var dep *Command
 	for _, c := range cmds {
 		// Link dependencies for the command.
-		for _, d := range c.mc.Deps {
+		for i, d := range c.mc.Deps {
+			pos := c.mc.PosOf("deps", i)
 			if len(d) == 0 {
-				return fmt.Errorf("command '%s': empty dependency value", c.path)
+				return pos.Errorf("command '%s': empty dependency value", c.path)
 			}
 
 			dep, err = getCommandByPath(root, c, d)
 			if err != nil {
-				return fmt.Errorf("command '%s': invalid dependency value: %w", c.path, err)
+				return pos.Errorf("command '%s': invalid dependency value: %v", c.path, err)
 			}
 
 			// Ensure the depenceny has no arguments. Currently not supported.
 			if len(dep.mc.Args) > 0 {
-				return fmt.Errorf("command '%s': dependency command has args: currently unsupported", c.path)
+				return pos.Errorf("command '%s': dependency command has args: currently unsupported", c.path)
 			}
 
 			c.deps = append(c.deps, dep)
 		}
 
+		// Link the fanout commands.
+		if f := c.mc.Fanout; f != nil {
+			pos := c.mc.PosOf("fanout")
+			if f.Command == "" {
+				return pos.Errorf("command '%s': empty fanout command", c.path)
+			}
+			c.fanout = root.IncludeTargets(c, f.Command)
+			for _, t := range c.fanout {
+				if len(t.mc.Args) > 0 {
+					return pos.Errorf("command '%s': fanout command '%s' has args: currently unsupported", c.path, t.path)
+				}
+			}
+		}
+
 		// Link all dependencies for all sub commands.
 		err = linkDeps(root, c.cmds)
 		if err != nil {
@@ -0,0 +1,222 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package docs generates the reference documentation of a grml file:
+// its command tree and the options of each scope.
+package docs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grml/internal/manifest"
+	"github.com/desertbit/grml/internal/options"
+)
+
+// Formats are the supported output formats.
+var Formats = []string{"markdown", "man", "html"}
+
+// Config defines what is documented.
+type Config struct {
+	Manifest *manifest.Manifest
+	Commands cmd.Commands
+
+	// Options keyed by scope path with their default values. The root
+	// scope is "".
+	Options map[string]*options.Options
+}
+
+// doc is the format independent model of the documentation.
+type doc struct {
+	Project  string
+	Commands []command
+	Scopes   []scope
+}
+
+type command struct {
+	Name        string // path with spaces, as typed in the shell
+	Help        string
+	Description string // only set if it differs from Help
+	Group       string
+	Aliases     []string
+	Args        []string
+	Deps        []string
+	Fanout      string
+	Source      string
+	Include     string
+}
+
+// Usage returns the command line of the command.
+func (c command) Usage() string {
+	s := "grml " + c.Name
+	for _, a := range c.Args {
+		s += " <" + a + ">"
+	}
+	return s
+}
+
+// Anchor returns the link target of the command.
+func (c command) Anchor() string {
+	return strings.ReplaceAll(c.Name, " ", "-")
+}
+
+type scope struct {
+	Path    string // "" for the root scope
+	Options []option
+}
+
+type option struct {
+	Key     string // as passed to '-o', e.g. 'release.dryrun'
+	Name    string
+	Type    string
+	Default string
+	Values  string // valid values or bounds
+	Env     []string
+	Help    string
+}
+
+// Generate writes the documentation in the format to w.
+func Generate(w io.Writer, format string, conf Config) error {
+	d := build(conf)
+	switch format {
+	case "markdown", "md":
+		return markdownTemplate.Execute(w, d)
+	case "man":
+		return manTemplate.Execute(w, d)
+	case "html":
+		return htmlTemplate.Execute(w, d)
+	default:
+		return fmt.Errorf("unknown format '%s': expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+func build(conf Config) *doc {
+	d := &doc{Project: conf.Manifest.Project}
+	addCommands(d, conf.Commands)
+
+	paths := make([]string, 0, len(conf.Options))
+	for p := range conf.Options {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		d.Scopes = append(d.Scopes, newScope(p, conf.Options[p]))
+	}
+	return d
+}
+
+// addCommands adds the commands sorted by name, each followed by its
+// sub commands. Hidden commands and their sub commands are skipped.
+func addCommands(d *doc, cs cmd.Commands) {
+	sorted := append(cmd.Commands{}, cs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+
+	for _, c := range sorted {
+		if c.Hidden() {
+			continue
+		}
+
+		dc := command{
+			Name:    strings.ReplaceAll(c.Path(), ".", " "),
+			Help:    c.Help(),
+			Group:   c.Group(),
+			Aliases: c.Alias(),
+			Args:    c.Args(),
+			Deps:    c.DepPaths(),
+			Fanout:  c.FanoutPath(),
+			Source:  c.Pos().String(),
+			Include: c.Include(),
+		}
+		if desc := c.Description(); desc != c.Help() {
+			dc.Description = strings.TrimSpace(desc)
+		}
+		d.Commands = append(d.Commands, dc)
+
+		addCommands(d, c.SubCommands())
+	}
+}
+
+func newScope(path string, o *options.Options) scope {
+	s := scope{Path: path}
+	defaults := o.Env()
+	for _, name := range o.Names() {
+		opt := option{
+			Key:     name,
+			Name:    name,
+			Type:    o.Type(name),
+			Default: defaults[name],
+			Env:     []string{name},
+			Help:    o.Help(name),
+		}
+
+		switch opt.Type {
+		case "choice":
+			opt.Values = strings.Join(o.Choices[name].Options, ", ")
+		case "multi":
+			opt.Values = strings.Join(o.Multis[name].Options, ", ")
+		case "int":
+			opt.Values = bounds(o.Ints[name])
+		case "string":
+			if p := o.Strings[name].Pattern; p != nil {
+				opt.Values = "/" + p.String() + "/"
+			}
+		}
+
+		if path != "" {
+			opt.Key = path + "." + name
+		}
+
+		// Variables mapped to the option's values.
+		var mapped []string
+		seen := make(map[string]bool)
+		for _, vars := range o.ValueEnvs[name] {
+			for k := range vars {
+				if !seen[k] {
+					seen[k] = true
+					mapped = append(mapped, k)
+				}
+			}
+		}
+		sort.Strings(mapped)
+		opt.Env = append(opt.Env, mapped...)
+
+		s.Options = append(s.Options, opt)
+	}
+	return s
+}
+
+// bounds returns the inclusive bounds of an int option, e.g. "1..64".
+func bounds(i *options.Int) string {
+	if i.Min == nil && i.Max == nil {
+		return ""
+	}
+	var min, max string
+	if i.Min != nil {
+		min = strconv.Itoa(*i.Min)
+	}
+	if i.Max != nil {
+		max = strconv.Itoa(*i.Max)
+	}
+	return min + ".." + max
+}
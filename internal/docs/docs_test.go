@@ -0,0 +1,108 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package docs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grml/internal/manifest"
+)
+
+const testManifest = `version: 3
+project: docs
+options:
+    debug: {type: bool, default: false, help: build for debugging}
+    jobs: {type: int, default: 4, min: 1, max: 8}
+commands:
+    build:
+        help: build it | fast
+        alias: [b]
+        deps: [prepare]
+        exec: echo
+    prepare:
+        help: prepare
+        internal: true
+        exec: echo
+    deploy:
+        help: deploy
+        args: [host]
+        exec: echo
+        options:
+            channel:
+                options: [stable, beta]
+                values:
+                    beta: {SUFFIX: -beta}
+`
+
+func TestGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grml.yaml")
+	if err := os.WriteFile(path, []byte(testManifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := cmd.ParseManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := m.ParseOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := Config{Manifest: m, Commands: cmds, Options: opts}
+
+	var b bytes.Buffer
+	if err := Generate(&b, "markdown", conf); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"| [build](#build) | build it \\| fast |",
+		"- Usage: `grml deploy <host>`",
+		"- Aliases: `b`",
+		"- Deps: `prepare`",
+		"| `debug` | bool | false |  | `debug` | build for debugging |",
+		"| `jobs` | int | 4 | 1..8 | `jobs` |  |",
+		"| `deploy.channel` | choice | stable | stable, beta | `channel`, `SUFFIX` |  |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "### prepare") {
+		t.Errorf("internal command documented:\n%s", out)
+	}
+
+	for _, format := range []string{"man", "html"} {
+		b.Reset()
+		if err := Generate(&b, format, conf); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+	if err := Generate(&b, "pdf", conf); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package docs
+
+import (
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+var funcs = template.FuncMap{
+	"cell":  markdownCell,
+	"codes": markdownCodes,
+	"roff":  roff,
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(funcs).Parse(`# {{.Project}}
+
+<!-- Generated by 'grml docs', do not edit. -->
+{{- if .Commands}}
+
+## Commands
+
+| Command | Description |
+|:--------|:------------|
+{{- range .Commands}}
+| [{{.Name}}](#{{.Anchor}}) | {{cell .Help}} |
+{{- end}}
+{{- range .Commands}}
+
+### {{.Name}}
+
+{{.Help}}
+{{- with .Description}}
+
+{{.}}
+{{- end}}
+
+- Usage: ` + "`{{.Usage}}`" + `
+{{- with .Aliases}}
+- Aliases: {{codes .}}
+{{- end}}
+{{- with .Deps}}
+- Deps: {{codes .}}
+{{- end}}
+{{- with .Fanout}}
+- Fanout: ` + "`{{.}}`" + `
+{{- end}}
+{{- with .Group}}
+- Group: {{.}}
+{{- end}}
+- Source: ` + "`{{.Source}}`" + `
+{{- with .Include}}
+- Includes: ` + "`{{.}}`" + `
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Scopes}}
+
+## Options
+
+Set with ` + "`grml -o key=value`" + ` or ` + "`options set`" + ` in the shell. Each option is exported as an env variable of the same name.
+{{- range .Scopes}}
+
+### {{if .Path}}{{.Path}} options{{else}}Global options{{end}}
+
+| Option | Type | Default | Values | Env | Description |
+|:-------|:-----|:--------|:-------|:----|:------------|
+{{- range .Options}}
+| ` + "`{{.Key}}`" + ` | {{.Type}} | {{cell .Default}} | {{cell .Values}} | {{codes .Env}} | {{cell .Help}} |
+{{- end}}
+{{- end}}
+{{- end}}
+`))
+
+var manTemplate = template.Must(template.New("man").Funcs(funcs).Parse(`.TH "{{upper .Project | roff}}" 7 "" "grml" "{{roff .Project}} tasks"
+.SH NAME
+{{roff .Project}} \- commands and options of the grml file
+{{- if .Commands}}
+.SH COMMANDS
+{{- range .Commands}}
+.TP
+.B {{roff .Usage}}
+{{roff .Help}}
+{{- with .Description}}
+.IP
+{{roff .}}
+{{- end}}
+.IP
+{{- with .Aliases}}
+Aliases: {{join . ", " | roff}}
+.br
+{{- end}}
+{{- with .Deps}}
+Deps: {{join . ", " | roff}}
+.br
+{{- end}}
+{{- with .Fanout}}
+Fanout: {{roff .}}
+.br
+{{- end}}
+{{- with .Group}}
+Group: {{roff .}}
+.br
+{{- end}}
+Source: {{roff .Source}}
+{{- with .Include}}
+.br
+Includes: {{roff .}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Scopes}}
+.SH OPTIONS
+Set with \fBgrml \-o key=value\fR or \fBoptions set\fR in the shell.
+Each option is exported as an env variable of the same name.
+{{- range .Scopes}}
+.SS {{if .Path}}{{roff .Path}} options{{else}}Global options{{end}}
+{{- range .Options}}
+.TP
+.B {{roff .Key}}
+({{.Type}}{{with .Default}}, default: {{roff .}}{{end}}) {{roff .Help}}
+{{- with .Values}}
+.br
+Values: {{roff .}}
+{{- end}}
+.br
+Env: {{join .Env ", " | roff}}
+{{- end}}
+{{- end}}
+{{- end}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcs)).Parse(`<!DOCTYPE html>
+<!-- Generated by 'grml docs', do not edit. -->
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Project}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: .2em .5em; text-align: left; vertical-align: top; }
+.description { white-space: pre-line; }
+</style>
+</head>
+<body>
+<h1>{{.Project}}</h1>
+{{- if .Commands}}
+<h2>Commands</h2>
+<table>
+<tr><th>Command</th><th>Description</th></tr>
+{{- range .Commands}}
+<tr><td><a href="#{{.Anchor}}">{{.Name}}</a></td><td>{{.Help}}</td></tr>
+{{- end}}
+</table>
+{{- range .Commands}}
+<h3 id="{{.Anchor}}">{{.Name}}</h3>
+<p>{{.Help}}</p>
+{{- with .Description}}
+<p class="description">{{.}}</p>
+{{- end}}
+<dl>
+<dt>Usage</dt><dd><code>{{.Usage}}</code></dd>
+{{- with .Aliases}}
+<dt>Aliases</dt><dd>{{join . ", "}}</dd>
+{{- end}}
+{{- with .Deps}}
+<dt>Deps</dt><dd>{{join . ", "}}</dd>
+{{- end}}
+{{- with .Fanout}}
+<dt>Fanout</dt><dd>{{.}}</dd>
+{{- end}}
+{{- with .Group}}
+<dt>Group</dt><dd>{{.}}</dd>
+{{- end}}
+<dt>Source</dt><dd><code>{{.Source}}</code></dd>
+{{- with .Include}}
+<dt>Includes</dt><dd><code>{{.}}</code></dd>
+{{- end}}
+</dl>
+{{- end}}
+{{- end}}
+{{- if .Scopes}}
+<h2>Options</h2>
+<p>Set with <code>grml -o key=value</code> or <code>options set</code> in the shell. Each option is exported as an env variable of the same name.</p>
+{{- range .Scopes}}
+<h3>{{if .Path}}{{.Path}} options{{else}}Global options{{end}}</h3>
+<table>
+<tr><th>Option</th><th>Type</th><th>Default</th><th>Values</th><th>Env</th><th>Description</th></tr>
+{{- range .Options}}
+<tr><td><code>{{.Key}}</code></td><td>{{.Type}}</td><td>{{.Default}}</td><td>{{.Values}}</td><td>{{join .Env ", "}}</td><td>{{.Help}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// markdownCell escapes s for a markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// markdownCodes returns the values as a comma separated list of inline
+// code spans.
+func markdownCodes(values []string) string {
+	codes := make([]string, len(values))
+	for i, v := range values {
+		codes[i] = "`" + v + "`"
+	}
+	return strings.Join(codes, ", ")
+}
+
+// roff escapes s for a man page. Lines must not start with a control
+// character.
+func roff(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, ".") || strings.HasPrefix(l, "'") {
+			lines[i] = `\&` + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
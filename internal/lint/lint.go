@@ -0,0 +1,510 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package lint reports problems in a grml manifest and its includes
+// that would otherwise only show up, if at all, when running a command.
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/grml/internal/cmd"
+	"github.com/desertbit/grml/internal/manifest"
+	"github.com/desertbit/grml/internal/options"
+)
+
+// Config defines the environment a manifest is linted in.
+type Config struct {
+	// Path of the manifest file.
+	Path string
+
+	// Overlays are merged on top of the manifest in order.
+	Overlays []string
+
+	// Env visible to the manifest: the process environment plus the
+	// implicit variables set by grml, except PROJECT which is taken from
+	// the manifest.
+	Env map[string]string
+
+	// Implicit variable names set by grml. Args must not shadow them.
+	Implicit []string
+
+	// Builtins are the top-level builtin command names.
+	Builtins []string
+}
+
+// Problem is a single finding. Line and Column are zero if unknown.
+type Problem struct {
+	File    string // relative to the manifest's directory
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.File == "":
+		return p.Message
+	case p.Line == 0:
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+	}
+}
+
+// varRef matches ${VAR} references.
+var varRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+type linter struct {
+	conf     Config
+	root     string // the ROOT directory, import paths are relative to it
+	m        *manifest.Manifest
+	cmds     cmd.Commands
+	scopes   map[string]*options.Options
+	problems []Problem
+
+	// used holds the commands run by others as dep or fanout target.
+	// Computed on first use.
+	used map[*cmd.Command]bool
+}
+
+// positioned is a manifest node that knows where its fields are declared.
+type positioned interface {
+	PosOf(keys ...interface{}) manifest.Pos
+}
+
+type command struct {
+	path string
+	mc   *manifest.Command
+	c    *cmd.Command
+	env  map[string]bool // variables defined for this command
+}
+
+// Run lints the manifest and returns all problems sorted by location.
+// A manifest that fails to parse yields its parse errors only.
+func Run(conf Config) []Problem {
+	m, err := manifest.Parse(conf.Path, conf.Overlays...)
+	if err != nil {
+		var problems []Problem
+		for _, msg := range strings.Split(err.Error(), "\n") {
+			problems = append(problems, Problem{Message: msg})
+		}
+		return problems
+	}
+
+	l := &linter{
+		conf: conf,
+		root: conf.Env["ROOT"],
+		m:    m,
+	}
+	l.cmds, err = cmd.Build(m)
+	if err != nil {
+		// The error is prefixed with its position already.
+		l.report(manifest.Pos{}, "%v", err)
+	}
+	if l.root == "" {
+		l.root = filepath.Dir(conf.Path)
+	}
+
+	// The deprecations are prefixed with their position already.
+	for _, d := range m.Deprecations {
+		l.report(manifest.Pos{}, "%s", d)
+	}
+
+	l.scopes, err = m.ParseOptions()
+	if err != nil {
+		// The error is prefixed with its position already.
+		l.report(manifest.Pos{}, "%v", err)
+		l.scopes = make(map[string]*options.Options)
+	}
+
+	// Root scope.
+	env := make(map[string]bool, len(conf.Env)+1)
+	for k := range conf.Env {
+		env[k] = true
+	}
+	env["PROJECT"] = true
+	env = l.lintEnv(m, m.Env, env)
+	l.lintImports(m, m.Import)
+	l.lintOptions("", m)
+
+	// Commands.
+	l.lintCommands("", m.Commands, env)
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		a, b := l.problems[i], l.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.problems
+}
+
+func (l *linter) report(pos manifest.Pos, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{
+		File:    pos.File,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// lintCommands lints the sibling commands mcs.
+func (l *linter) lintCommands(parentPath string, mcs manifest.Commands, parentEnv map[string]bool) {
+	names := make([]string, 0, len(mcs))
+	for name := range mcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var siblings []*command
+	for _, name := range names {
+		mc := mcs[name]
+		path := name
+		if parentPath != "" {
+			path = parentPath + "." + name
+		}
+
+		c := &command{path: path, mc: mc}
+		c.c, _ = l.cmds.Lookup(nil, path)
+
+		// The matrix variables are exported to the variants, after the env
+		// of the command.
+		c.env = l.lintEnv(mc, mc.Env, parentEnv)
+		if mc.Matrix != nil {
+			env := make(map[string]bool, len(c.env)+len(mc.Matrix.Vars))
+			for k := range c.env {
+				env[k] = true
+			}
+			for _, v := range mc.Matrix.Vars {
+				env[v.Name] = true
+			}
+			c.env = env
+		}
+		l.lintHelp(c)
+		l.lintArgs(c)
+		l.lintDeps(c)
+		l.lintFanout(c)
+		l.lintInternal(c)
+		l.lintImports(mc, mc.Import)
+		l.lintOptions(path, mc)
+
+		if mc.Exec == "" && len(mc.Deps) == 0 && mc.Fanout == nil && len(mc.Commands) == 0 {
+			l.report(mc.Pos, "command '%s' has neither exec nor deps", path)
+		}
+
+		siblings = append(siblings, c)
+		l.lintCommands(path, mc.Commands, c.env)
+	}
+
+	l.lintNames(parentPath, siblings)
+}
+
+// lintEnv reports ${VAR} references to variables neither defined earlier
+// in scope nor in parent. Returns the variables defined after the scope.
+func (l *linter) lintEnv(at positioned, scope manifest.Env, parent map[string]bool) map[string]bool {
+	if len(scope) == 0 {
+		return parent
+	}
+
+	env := make(map[string]bool, len(parent)+len(scope))
+	for k := range parent {
+		env[k] = true
+	}
+	for _, item := range scope {
+		key := item.Key
+		for _, ref := range varRefs(item.Value) {
+			if !env[ref] {
+				l.report(at.PosOf("env", key), "env '%s' references undefined variable '%s'", key, ref)
+			}
+		}
+		env[key] = true
+	}
+	return env
+}
+
+func (l *linter) lintHelp(c *command) {
+	for _, ref := range varRefs(c.mc.Help) {
+		if !c.env[ref] {
+			l.report(c.mc.PosOf("help"), "help of '%s' references undefined variable '%s'", c.path, ref)
+		}
+	}
+}
+
+// lintArgs reports args shadowing env variables of the manifest or
+// implicit variables.
+func (l *linter) lintArgs(c *command) {
+	for i, arg := range c.mc.Args {
+		if l.isManifestVar(c, arg) {
+			l.report(c.mc.PosOf("args", i), "arg '%s' of '%s' shadows env variable '%s'", arg, c.path, arg)
+		}
+	}
+}
+
+func (l *linter) isManifestVar(c *command, name string) bool {
+	for _, v := range l.conf.Implicit {
+		if v == name {
+			return true
+		}
+	}
+	for _, item := range l.m.Env {
+		if item.Key == name {
+			return true
+		}
+	}
+	if c.c != nil {
+		for _, scope := range c.c.Envs() {
+			for _, item := range scope {
+				if item.Key == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (l *linter) lintDeps(c *command) {
+	if c.c == nil {
+		return
+	}
+	for i, d := range c.mc.Deps {
+		dep, err := l.cmds.Lookup(c.c, d)
+		if err != nil {
+			l.report(c.mc.PosOf("deps", i), "dependency '%s' of '%s': %v", d, c.path, err)
+		} else if dep.HasArgs() {
+			l.report(c.mc.PosOf("deps", i), "dependency '%s' of '%s' has args: currently unsupported", d, c.path)
+		}
+	}
+}
+
+// lintFanout reports fanouts without a command to run. The fanout
+// commands are linked when parsing.
+func (l *linter) lintFanout(c *command) {
+	f := c.mc.Fanout
+	if c.c == nil || f == nil || f.Command == "" {
+		return
+	}
+	if len(l.cmds.IncludeTargets(c.c, f.Command)) == 0 {
+		l.report(c.mc.PosOf("fanout"), "fanout '%s' of '%s': no included subgrml defines the command", f.Command, c.path)
+	}
+}
+
+// lintInternal reports internal commands that can never run.
+func (l *linter) lintInternal(c *command) {
+	if !c.mc.Internal || c.c == nil {
+		return
+	}
+	if len(c.mc.Args) > 0 {
+		l.report(c.mc.PosOf("internal"), "internal command '%s' has args and can't be a dependency", c.path)
+	} else if !l.isUsed(c.c) {
+		l.report(c.mc.PosOf("internal"), "internal command '%s' is never used as a dependency", c.path)
+	}
+}
+
+// isUsed returns true if c runs as dep or fanout target of another command.
+func (l *linter) isUsed(c *cmd.Command) bool {
+	if l.used == nil {
+		l.used = make(map[*cmd.Command]bool)
+		l.cmds.Walk(func(from *cmd.Command) {
+			for _, d := range from.DepPaths() {
+				if dep, err := l.cmds.Lookup(from, d); err == nil {
+					l.used[dep] = true
+				}
+			}
+			if f := from.FanoutPath(); f != "" {
+				for _, t := range l.cmds.IncludeTargets(from, f) {
+					l.used[t] = true
+				}
+			}
+		})
+	}
+	return l.used[c]
+}
+
+// lintImports reports import files that don't exist. Paths are relative
+// to ROOT and may contain ${VAR} references.
+func (l *linter) lintImports(at positioned, imports []string) {
+	env := manifest.EvalEnvSlice(l.m.Env, l.conf.Env)
+	env["PROJECT"] = l.m.Project
+	for i, s := range imports {
+		path := s
+		for k, v := range env {
+			path = strings.Replace(path, "${"+k+"}", v, -1)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(l.root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			l.report(at.PosOf("import", i), "import '%s' does not exist", s)
+		}
+	}
+}
+
+// lintOptions reports options of the scope at path that are never
+// referenced by the commands in the scope or the scripts they import.
+func (l *linter) lintOptions(path string, at positioned) {
+	opts := l.scopes[path]
+	if opts == nil {
+		return
+	}
+
+	text := l.scopeText(path)
+	for _, name := range opts.Names() {
+		if _, ok := opts.ValueEnvs[name]; ok {
+			continue // Used through its mapped env variables.
+		}
+		ref := regexp.MustCompile(`(\$\{?|grml_(option|if)\s+["']?)` + regexp.QuoteMeta(name) + `\b`)
+		if !ref.MatchString(text) {
+			l.report(at.PosOf("options", name), "option '%s' is never referenced", name)
+		}
+	}
+}
+
+// scopeText returns the exec bodies, hooks, functions, help texts and env
+// values of all commands in the scope at path, plus the content of their
+// imports.
+func (l *linter) scopeText(path string) string {
+	var (
+		b       strings.Builder
+		imports = make(map[string]bool)
+	)
+	for _, s := range l.m.Import {
+		imports[s] = true
+	}
+	if path == "" {
+		writeHooks(&b, l.m.Hooks)
+		writeFunctions(&b, l.m.Functions)
+	}
+
+	l.cmds.Walk(func(c *cmd.Command) {
+		if path != "" && c.Path() != path && !strings.HasPrefix(c.Path(), path+".") {
+			return
+		}
+		b.WriteString(c.ExecString())
+		b.WriteString("\n")
+		b.WriteString(c.Help())
+		b.WriteString("\n")
+		if hooks := c.Hooks(); len(hooks) > 0 && hooks[len(hooks)-1].Path == c.Path() {
+			writeHooks(&b, hooks[len(hooks)-1].Hooks)
+		}
+		writeFunctions(&b, c.DeclaredFunctions())
+		for _, scope := range c.Envs() {
+			for _, item := range scope {
+				b.WriteString(item.Value)
+				b.WriteString("\n")
+			}
+		}
+		for _, s := range c.Imports() {
+			imports[s] = true
+		}
+	})
+
+	for s := range imports {
+		data, err := ioutil.ReadFile(filepath.Join(l.root, s))
+		if err == nil {
+			b.Write(data)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func writeHooks(b *strings.Builder, h *manifest.Hooks) {
+	if h == nil {
+		return
+	}
+	for _, s := range []string{h.BeforeAll, h.BeforeEach, h.AfterEach, h.AfterAll} {
+		b.WriteString(s)
+		b.WriteString("\n")
+	}
+}
+
+func writeFunctions(b *strings.Builder, fns map[string]string) {
+	for _, body := range fns {
+		b.WriteString(body)
+		b.WriteString("\n")
+	}
+}
+
+// lintNames reports command names and aliases colliding with siblings
+// or builtins.
+func (l *linter) lintNames(parentPath string, siblings []*command) {
+	builtins := l.conf.Builtins
+	if parentPath != "" {
+		builtins = nil
+		if _, ok := l.scopes[parentPath]; ok {
+			builtins = []string{"options"}
+		}
+	}
+	isBuiltin := func(name string) bool {
+		for _, b := range builtins {
+			if b == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range siblings {
+		name := c.path[strings.LastIndex(c.path, ".")+1:]
+		if isBuiltin(name) {
+			l.report(c.mc.Pos, "command '%s' collides with builtin '%s'", c.path, name)
+		}
+
+		for i, alias := range c.mc.Alias {
+			if isBuiltin(alias) {
+				l.report(c.mc.PosOf("alias", i), "alias '%s' of '%s' collides with builtin '%s'", alias, c.path, alias)
+				continue
+			}
+			for _, s := range siblings {
+				sname := s.path[strings.LastIndex(s.path, ".")+1:]
+				if alias == sname {
+					l.report(c.mc.PosOf("alias", i), "alias '%s' of '%s' collides with command '%s'", alias, c.path, s.path)
+				} else if s != c && contains(s.mc.Alias, alias) {
+					l.report(c.mc.PosOf("alias", i), "alias '%s' of '%s' collides with alias of '%s'", alias, c.path, s.path)
+				}
+			}
+		}
+	}
+}
+
+func varRefs(s string) (refs []string) {
+	for _, m := range varRef.FindAllStringSubmatch(s, -1) {
+		refs = append(refs, m[1])
+	}
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
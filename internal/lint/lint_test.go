@@ -0,0 +1,151 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testManifest = `version: 3
+project: lint
+env:
+    A: ${ROOT}/a
+    B: ${MISSING}/b
+options:
+    debug: false
+    unused: true
+import:
+    - nope.sh
+commands:
+    reload:
+        help: shadows builtin
+        exec: echo
+    build:
+        help: build ${A} ${debug}
+        alias: [b, test]
+        deps:
+            - test
+            - nothere
+        exec: |
+            if grml_option debug; then echo; fi
+    test:
+        alias: [b]
+        args: [A]
+        exec: echo
+    empty:
+        help: nothing
+    sub:
+        include: sub/grml.yaml
+`
+	testInclude = `env:
+    X: ${LOCAL_ROOT}/x ${Y}
+options:
+    flag: false
+import:
+    - missing.sh
+help: sub
+commands:
+    run:
+        deps: [~.gone]
+        exec: echo ${flag}
+    helper:
+        help: never used
+        internal: true
+        exec: echo
+`
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"grml.yaml":     testManifest,
+		"sub/grml.yaml": testInclude,
+	})
+
+	problems := Run(Config{
+		Path:     filepath.Join(dir, "grml.yaml"),
+		Env:      map[string]string{"ROOT": dir, "NUMCPU": "1"},
+		Implicit: []string{"ROOT", "PROJECT", "NUMCPU", "LOCAL_ROOT"},
+		Builtins: []string{"reload", "help"},
+	})
+
+	want := []string{
+		"grml.yaml:5:5: env 'B' references undefined variable 'MISSING'",
+		"grml.yaml:8:5: option 'unused' is never referenced",
+		"grml.yaml:10:7: import 'nope.sh' does not exist",
+		"grml.yaml:12:5: command 'reload' collides with builtin 'reload'",
+		"grml.yaml:16:9: help of 'build' references undefined variable 'debug'",
+		"grml.yaml:17:17: alias 'b' of 'build' collides with alias of 'test'",
+		"grml.yaml:17:20: alias 'test' of 'build' collides with command 'test'",
+		"grml.yaml:19:15: dependency 'test' of 'build' has args: currently unsupported",
+		"grml.yaml:20:15: dependency 'nothere' of 'build': command not found by path: nothere",
+		"grml.yaml:24:17: alias 'b' of 'test' collides with alias of 'build'",
+		"grml.yaml:25:16: arg 'A' of 'test' shadows env variable 'A'",
+		"grml.yaml:27:5: command 'empty' has neither exec nor deps",
+		"sub/grml.yaml:2:5: env 'X' references undefined variable 'Y'",
+		"sub/grml.yaml:6:7: import 'sub/missing.sh' does not exist",
+		"sub/grml.yaml:10:16: dependency '~.gone' of 'sub.run': command not found by path: sub.gone",
+		"sub/grml.yaml:14:9: internal command 'sub.helper' is never used as a dependency",
+	}
+	if len(problems) != len(want) {
+		t.Errorf("got %d problems, want %d", len(problems), len(want))
+	}
+	for i := 0; i < len(problems) && i < len(want); i++ {
+		if got := problems[i].String(); got != want[i] {
+			t.Errorf("problem %d:\n got: %s\nwant: %s", i, got, want[i])
+		}
+	}
+}
+
+func TestRunParseError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"grml.yaml": "version: 3\nproject: x\ncommands:\n    a:\n        exec: x\n        foo: 1\n        bar: 2\n",
+	})
+
+	problems := Run(Config{Path: filepath.Join(dir, "grml.yaml")})
+	want := []string{
+		"grml.yaml:6:9: field foo not found in type manifest.Command",
+		"grml.yaml:7:9: field bar not found in type manifest.Command",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got %v, want %v", problems, want)
+	}
+	for i, p := range problems {
+		if p.String() != want[i] {
+			t.Errorf("got %q, want %q", p, want[i])
+		}
+	}
+}
@@ -19,43 +19,236 @@
 package manifest
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/desertbit/grml/internal/options"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	Version = 3
+
+	// MinVersion is the oldest version that is still read. Older
+	// versions are deprecated and can be upgraded with Migrate.
+	MinVersion = 1
 )
 
 type Manifest struct {
+	origin `yaml:"-"`
+
 	Version int    `yaml:"version"`
 	Project string `yaml:"project"`
 
-	Env         yaml.MapSlice          `yaml:"env"` // Use MapSlice to preserve order.
+	Env         Env                    `yaml:"env"`
 	Options     map[string]interface{} `yaml:"options"`
+	Profiles    map[string]Profile     `yaml:"profiles"`
 	Interpreter string                 `yaml:"interpreter"`
 	Import      []string               `yaml:"import"`
+	Functions   map[string]string      `yaml:"functions"` // Shell functions defined before every exec body.
+	Hooks       *Hooks                 `yaml:"hooks"`
+	Includes    StringList             `yaml:"includes"`  // Globs of subgrml files mounted as commands.
+	Templates   Commands               `yaml:"templates"` // Command skeletons instantiated with 'use'.
 	Commands    Commands               `yaml:"commands"`
+
+	// Deprecations lists the deprecated constructs found while parsing,
+	// each prefixed with its position.
+	Deprecations []string `yaml:"-"`
+}
+
+// Profile is a named set of option values keyed by '[command.]name'.
+type Profile map[string]interface{}
+
+// StringList is a list of strings that may be declared as a single one.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(l))
+}
+
+// Hooks are shell bodies run around the commands of their scope.
+// before_all and after_all run once per run, around the first and after
+// the last command of the scope, the others around each command.
+type Hooks struct {
+	BeforeAll  string `yaml:"before_all"`
+	BeforeEach string `yaml:"before_each"`
+	AfterEach  string `yaml:"after_each"`
+	AfterAll   string `yaml:"after_all"`
+}
+
+func (h *Hooks) UnmarshalYAML(node *yaml.Node) error {
+	type plain Hooks
+	var o origin
+	return decodeStrict(node, &o, (*plain)(h), "manifest.Hooks")
+}
+
+// merge replaces the hooks declared by o.
+func (h *Hooks) merge(o *Hooks) *Hooks {
+	if h == nil || o == nil {
+		if o != nil {
+			return o
+		}
+		return h
+	}
+	n := *h
+	if o.BeforeAll != "" {
+		n.BeforeAll = o.BeforeAll
+	}
+	if o.BeforeEach != "" {
+		n.BeforeEach = o.BeforeEach
+	}
+	if o.AfterEach != "" {
+		n.AfterEach = o.AfterEach
+	}
+	if o.AfterAll != "" {
+		n.AfterAll = o.AfterAll
+	}
+	return &n
+}
+
+// Fanout runs the command at Command, a path relative to each include
+// point, in every included subtree defining it. Declared as the path
+// alone or in long form.
+type Fanout struct {
+	Command  string `yaml:"command"`
+	Parallel bool   `yaml:"parallel"`
+}
+
+func (f *Fanout) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Command = node.Value
+		return nil
+	}
+	type plain Fanout
+	var o origin
+	return decodeStrict(node, &o, (*plain)(f), "manifest.Fanout")
 }
 
 type Commands map[string]*Command
 
 type Command struct {
-	Alias    []string               `yaml:"alias"`
-	Help     string                 `yaml:"help"`
-	Args     []string               `yaml:"args"`
-	Env      yaml.MapSlice          `yaml:"env"`     // Scoped to this command and its descendants.
-	Options  map[string]interface{} `yaml:"options"` // Scoped to this command and its descendants.
-	Import   []string               `yaml:"import"`  // Sourced before exec for this command and its descendants.
-	Deps     []string               `yaml:"deps"`
-	Exec     string                 `yaml:"exec"`
-	Include  string                 `yaml:"include"`
-	Commands Commands               `yaml:"commands"`
+	origin `yaml:"-"`
+
+	Alias       []string               `yaml:"alias"`
+	Help        string                 `yaml:"help"`
+	Description string                 `yaml:"description"` // Long help, defaults to help.
+	Group       string                 `yaml:"group"`       // Help section of the command.
+	Hidden      bool                   `yaml:"hidden"`      // Omitted from help and completion.
+	Internal    bool                   `yaml:"internal"`    // Only runs as a dependency.
+	Args        []string               `yaml:"args"`
+	Env         Env                    `yaml:"env"`       // Scoped to this command and its descendants.
+	Options     map[string]interface{} `yaml:"options"`   // Scoped to this command and its descendants.
+	Import      []string               `yaml:"import"`    // Sourced before exec for this command and its descendants.
+	Functions   map[string]string      `yaml:"functions"` // Defined before exec for this command and its descendants.
+	Hooks       *Hooks                 `yaml:"hooks"`     // Run around this command and its descendants.
+	Deps        []string               `yaml:"deps"`
+	Fanout      *Fanout                `yaml:"fanout"` // Runs a command in every included subtree.
+	Use         *Use                   `yaml:"use"`    // Instantiates a template.
+	Params      []string               `yaml:"params"` // Placeholders of a template required by use.
+	Matrix      *Matrix                `yaml:"matrix"` // Expands into a sub command per variant.
+	Exec        string                 `yaml:"exec"`
+	Include     string                 `yaml:"include"`
+	Commands    Commands               `yaml:"commands"`
+}
+
+func (m *Manifest) UnmarshalYAML(node *yaml.Node) error {
+	type plain Manifest
+	err := decodeStrict(node, &m.origin, (*plain)(m), "manifest.Manifest")
+	orderOptionValues(m.Options, node)
+	m.Templates.setPos(&m.origin, "templates")
+	m.Commands.setPos(&m.origin, "commands")
+	return err
+}
+
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	type plain Command
+	err := decodeStrict(node, &c.origin, (*plain)(c), "manifest.Command")
+	orderOptionValues(c.Options, node)
+	c.Commands.setPos(&c.origin, "commands")
+	return err
+}
+
+// orderOptionValues keeps the declaration order of the 'values' of the
+// long-form options declared in the mapping node, which defines the order
+// of choices. Decoded maps lose the order of their keys.
+func orderOptionValues(opts map[string]interface{}, node *yaml.Node) {
+	optsNode := mappingValue(node, "options")
+	if optsNode == nil {
+		return
+	}
+	for name, v := range opts {
+		d, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		values, ok := d["values"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		valuesNode := mappingValue(mappingValue(optsNode, name), "values")
+		if valuesNode == nil {
+			continue
+		}
+		om := options.OrderedMap{Values: values}
+		for i := 0; i+1 < len(valuesNode.Content); i += 2 {
+			om.Keys = append(om.Keys, valuesNode.Content[i].Value)
+		}
+		d["values"] = om
+	}
+}
+
+// mappingValue returns the value node of key in the mapping node or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setPos sets the position of each command to its name's position in
+// the parent's block at key.
+func (cs Commands) setPos(parent *origin, key string) {
+	for name, c := range cs {
+		if c == nil {
+			continue
+		}
+		if p, ok := parent.fields[key+"."+name]; ok && c.Pos.File == "" {
+			c.Pos = p
+		}
+	}
+}
+
+// setFile sets the file of all positions of the commands and their
+// descendants without one.
+func (cs Commands) setFile(file string) {
+	for _, c := range cs {
+		c.setFile(file)
+		c.Commands.setFile(file)
+	}
+}
+
+// names returns the sorted command names.
+func (cs Commands) names() []string {
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (cs Commands) Count() (n int) {
@@ -74,11 +267,10 @@ func (m *Manifest) EvalEnv(parentEnv map[string]string) map[string]string {
 // ${VAR} references are expanded against earlier entries in the same scope
 // first, then against parentEnv. The returned map contains all parentEnv keys
 // plus the scope's overrides/additions.
-func EvalEnvSlice(scope yaml.MapSlice, parentEnv map[string]string) map[string]string {
+func EvalEnvSlice(scope Env, parentEnv map[string]string) map[string]string {
 	env := make(map[string]string, len(parentEnv)+len(scope))
 	for _, i := range scope {
-		key := fmt.Sprintf("%v", i.Key)
-		value := fmt.Sprintf("%v", i.Value)
+		key, value := i.Key, i.Value
 
 		for k, v := range env {
 			value = strings.Replace(value, fmt.Sprintf("${%s}", k), v, -1)
@@ -107,8 +299,10 @@ func (m *Manifest) ParseOptions() (scopes map[string]*options.Options, err error
 
 	if len(m.Options) > 0 {
 		o := options.New()
-		if err = o.Add(m.Options); err != nil {
-			return
+		for name, v := range m.Options {
+			if err = o.Add(name, v); err != nil {
+				return nil, m.PosOf("options", name).Errorf("%v", err)
+			}
 		}
 		scopes[""] = o
 	}
@@ -127,8 +321,10 @@ func collectCommandOptions(parentPath string, mcs Commands, scopes map[string]*o
 		}
 		if len(mc.Options) > 0 {
 			o := options.New()
-			if err := o.Add(mc.Options); err != nil {
-				return fmt.Errorf("command '%s': %v", path, err)
+			for name, v := range mc.Options {
+				if err := o.Add(name, v); err != nil {
+					return mc.PosOf("options", name).Errorf("command '%s': %v", path, err)
+				}
 			}
 			scopes[path] = o
 		}
@@ -139,40 +335,178 @@ func collectCommandOptions(parentPath string, mcs Commands, scopes map[string]*o
 	return nil
 }
 
-// Parse a grml build file.
-func Parse(path string) (m *Manifest, err error) {
-	data, err := ioutil.ReadFile(path)
+// Parse a grml build file. The overlay files are merged on top of it in
+// order. Unlike the grml file, overlays may omit the version and project.
+func Parse(path string, overlays ...string) (m *Manifest, err error) {
+	m, err = decodeFile(path, filepath.Base(path), 0)
 	if err != nil {
 		return
 	}
+	for _, o := range overlays {
+		file, err := filepath.Rel(filepath.Dir(path), o)
+		if err != nil {
+			file = filepath.Base(o)
+		}
+		om, err := decodeFile(o, file, m.Version)
+		if err != nil {
+			return nil, err
+		}
+		m.merge(om)
+	}
+
+	// Validate.
+	if m.Version < MinVersion || m.Version > Version {
+		err = m.PosOf("version").Errorf("incompatible grml version: file=%v current=%v", m.Version, Version)
+		return
+	} else if m.Version < Version {
+		m.Deprecations = append([]string{m.PosOf("version").Errorf(
+			"grml version %v is deprecated: run 'grml migrate' to upgrade to version %v", m.Version, Version).Error(),
+		}, m.Deprecations...)
+	}
+	if m.Project == "" {
+		err = m.PosOf("project").Errorf("no project name set")
+		return
+	}
 
-	m = &Manifest{}
-	err = yaml.UnmarshalStrict(data, m)
+	// Mount the glob includes and parse all inlcudes.
+	rootPath := filepath.Dir(path)
+	err = m.mountIncludes(rootPath)
+	if err != nil {
+		return
+	}
+	err = m.parseIncludes(rootPath, m.Commands)
 	if err != nil {
 		return
 	}
 
-	// Validate.
-	if m.Version != Version {
-		err = fmt.Errorf("incompatible grml version: file=%v current=%v", m.Version, Version)
+	// Instantiate the templates.
+	err = m.useTemplates("", m.Commands)
+	if err != nil {
 		return
-	} else if m.Project == "" {
-		err = fmt.Errorf("no project name set")
+	}
+
+	err = checkFunctions(&m.origin, "", m.Functions)
+	if err != nil {
 		return
 	}
+	err = checkCommandFunctions("", m.Commands)
+	return
+}
 
-	// Parse inlcudes.
-	rootPath := filepath.Dir(path)
-	err = parseIncludes(rootPath, m.Commands)
+// functionName matches the portable shell function names.
+var functionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkFunctions ensures the function names are valid and do not shadow
+// the grml_ builtins.
+func checkFunctions(o *origin, path string, fns map[string]string) error {
+	for name := range fns {
+		var problem string
+		if !functionName.MatchString(name) {
+			problem = "invalid name"
+		} else if strings.HasPrefix(name, "grml_") {
+			problem = "the grml_ prefix is reserved for builtins"
+		} else {
+			continue
+		}
+		if path != "" {
+			return o.PosOf("functions", name).Errorf("command '%s': function '%s': %s", path, name, problem)
+		}
+		return o.PosOf("functions", name).Errorf("function '%s': %s", name, problem)
+	}
+	return nil
+}
+
+func checkCommandFunctions(parentPath string, mcs Commands) error {
+	for name, mc := range mcs {
+		path := name
+		if parentPath != "" {
+			path = parentPath + "." + name
+		}
+		if err := checkFunctions(&mc.origin, path, mc.Functions); err != nil {
+			return err
+		}
+		if err := checkCommandFunctions(path, mc.Commands); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountIncludes adds a command for each file matched by the 'includes'
+// globs, named after the file's directory.
+func (m *Manifest) mountIncludes(rootPath string) error {
+	for i, pattern := range m.Includes {
+		pos := m.PosOf("includes", i)
+		if filepath.IsAbs(pattern) {
+			return pos.Errorf("include '%s': must be relative to the root directory", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(rootPath, pattern))
+		if err != nil {
+			return pos.Errorf("include '%s': %v", pattern, err)
+		}
+
+		// Glob returns the matches sorted.
+		for _, match := range matches {
+			file, err := filepath.Rel(rootPath, match)
+			if err != nil {
+				return pos.Errorf("include '%s': %v", pattern, err)
+			}
+			name := filepath.Base(filepath.Dir(file))
+			if name == "." {
+				return pos.Errorf("include '%s': '%s' is not in a subdirectory", pattern, file)
+			} else if _, ok := m.Commands[name]; ok {
+				return pos.Errorf("include '%s': '%s': command '%s' already exists", pattern, file, name)
+			}
+
+			if m.Commands == nil {
+				m.Commands = make(Commands)
+			}
+			// The help text may be overridden by the include file.
+			c := &Command{Include: file, Help: "commands of " + file}
+			c.Pos = pos
+			m.Commands[name] = c
+		}
+	}
+	return nil
+}
+
+// decodeFile decodes the grml file at path. Positions refer to file.
+// Files of a deprecated version are upgraded before, version is used for
+// files that don't declare one.
+func decodeFile(path, file string, version int) (m *Manifest, err error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return
 	}
+	if v, err := sourceVersion(data); err == nil && v != 0 {
+		version = v
+	}
+	var deprecations []string
+	if version >= MinVersion && version < Version {
+		data, deprecations, err = upgrade(file, data, version)
+		if err != nil {
+			return
+		}
+	}
 
+	m = &Manifest{Deprecations: deprecations}
+	err = yaml.Unmarshal(data, m)
+	if err != nil {
+		return nil, yamlError(file, err)
+	}
+	m.setFile(file)
+	m.Templates.setFile(file)
+	m.Commands.setFile(file)
 	return
 }
 
-func parseIncludes(rootPath string, cmds Commands) (err error) {
-	for _, cmd := range cmds {
+// parseIncludes decodes the include files of the commands and their
+// descendants. Include files of a deprecated grml file are upgraded
+// before and their deprecations added to the manifest's.
+func (m *Manifest) parseIncludes(rootPath string, cmds Commands) (err error) {
+	// Sorted to report the deprecations in order.
+	for _, name := range cmds.names() {
+		cmd := cmds[name]
 		if cmd.Include == "" {
 			continue
 		}
@@ -180,13 +514,24 @@ func parseIncludes(rootPath string, cmds Commands) (err error) {
 		var data []byte
 		data, err = ioutil.ReadFile(filepath.Join(rootPath, cmd.Include))
 		if err != nil {
-			return
+			return cmd.PosOf("include").Errorf("%v", err)
+		}
+		if m.Version < Version {
+			var deprecations []string
+			data, deprecations, err = upgrade(cmd.Include, data, m.Version)
+			if err != nil {
+				return
+			}
+			m.Deprecations = append(m.Deprecations, deprecations...)
 		}
 
-		err = yaml.UnmarshalStrict(data, cmd)
+		err = yaml.Unmarshal(data, cmd)
 		if err != nil {
+			err = yamlError(cmd.Include, err)
 			return
 		}
+		cmd.setFile(cmd.Include)
+		cmd.Commands.setFile(cmd.Include)
 
 		includeDir := filepath.Dir(cmd.Include)
 
@@ -205,9 +550,9 @@ func parseIncludes(rootPath string, cmds Commands) (err error) {
 		} else {
 			localRoot = "${ROOT}/" + includeDir
 		}
-		cmd.Env = append(yaml.MapSlice{{Key: "LOCAL_ROOT", Value: localRoot}}, cmd.Env...)
+		cmd.Env = append(Env{{Key: "LOCAL_ROOT", Value: localRoot}}, cmd.Env...)
 
-		err = parseIncludes(rootPath, cmd.Commands)
+		err = m.parseIncludes(rootPath, cmd.Commands)
 		if err != nil {
 			return
 		}
@@ -215,6 +560,30 @@ func parseIncludes(rootPath string, cmds Commands) (err error) {
 	return
 }
 
+// yamlErrorLine matches the line prefix of yaml syntax errors.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// yamlError prefixes the line references of a yaml decoding error with
+// file, e.g. 'grml.yaml:12:9: field foo not found in type manifest.Command'.
+// Strict decoding reports one line per invalid field.
+func yamlError(file string, err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{yamlErrorLine.ReplaceAllString(err.Error(), "line $1: ")}
+	}
+
+	for i, msg := range msgs {
+		if strings.HasPrefix(msg, "line ") {
+			msgs[i] = file + ":" + strings.TrimPrefix(msg, "line ")
+		} else {
+			msgs[i] = file + ": " + msg
+		}
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
 // rewriteImportPaths makes every 'import:' path in cmd's subtree
 // root-relative, prefixing dir to non-absolute entries. Stops at nested
 // include points (those receive their own dir treatment when parsed).
@@ -0,0 +1,269 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files below a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// parseFiles parses grml.yaml of the files and the overlays.
+func parseFiles(t *testing.T, files map[string]string, overlays ...string) *Manifest {
+	t.Helper()
+	m, err := parseFilesErr(t, files, overlays...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func parseFilesErr(t *testing.T, files map[string]string, overlays ...string) (*Manifest, error) {
+	t.Helper()
+	dir := writeFiles(t, files)
+	for i, o := range overlays {
+		overlays[i] = filepath.Join(dir, o)
+	}
+	return Parse(filepath.Join(dir, "grml.yaml"), overlays...)
+}
+
+// expectErr fails unless err contains want.
+func expectErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected error containing %q", want)
+	} else if !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %q, want it to contain %q", err, want)
+	}
+}
+
+func TestOptionValuesOrder(t *testing.T) {
+	m := parseFiles(t, map[string]string{"grml.yaml": `version: 3
+project: p
+options:
+    runtime:
+        values:
+            cuda: {GPU: 1}
+            cpu: {GPU: 0}
+commands:
+    build:
+        help: build
+        options:
+            level:
+                values:
+                    high: {}
+                    low: {}
+                    medium: {}
+        exec: echo
+`})
+	scopes, err := m.ParseOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scopes[""].Choices["runtime"]; strings.Join(got.Options, ",") != "cuda,cpu" || got.Active != "cuda" {
+		t.Errorf("runtime: got %v, active %q", got.Options, got.Active)
+	}
+	if got := scopes["build"].Choices["level"]; strings.Join(got.Options, ",") != "high,low,medium" {
+		t.Errorf("level: got %v", got.Options)
+	}
+}
+
+func TestMountIncludes(t *testing.T) {
+	m := parseFiles(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+includes:
+    - services/*/grml.yaml
+    - tools/*.yaml
+    - missing/*/grml.yaml
+commands:
+    build:
+        exec: go build
+`,
+		"services/api/grml.yaml": "help: the api\ncommands:\n    test:\n        exec: go test\n",
+		"services/web/grml.yaml": "commands:\n    test:\n        exec: npm test\n",
+		"tools/lint.yaml":        "exec: lint\n",
+	})
+
+	// The glob matching nothing mounts no command.
+	if len(m.Commands) != 4 {
+		t.Fatalf("commands %v", m.Commands)
+	}
+	for name, want := range map[string]string{
+		"api":   "the api",
+		"web":   "commands of services/web/grml.yaml",
+		"tools": "commands of tools/lint.yaml",
+	} {
+		c := m.Commands[name]
+		if c == nil {
+			t.Errorf("command %s not mounted", name)
+		} else if c.Help != want {
+			t.Errorf("command %s: help %q, want %q", name, c.Help, want)
+		}
+	}
+	if c := m.Commands["api"].Commands["test"]; c == nil || c.Pos.File != "services/api/grml.yaml" {
+		t.Errorf("api.test: %+v", c)
+	}
+	if env := m.Commands["web"].Env; len(env) == 0 || env[0] != (EnvVar{Key: "LOCAL_ROOT", Value: "${ROOT}/services/web"}) {
+		t.Errorf("web env %v", env)
+	}
+}
+
+func TestMountIncludesErrors(t *testing.T) {
+	for _, c := range []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{
+			"grml.yaml":        "version: 3\nproject: p\nincludes: [a/*/grml.yaml, b/*/grml.yaml]\n",
+			"a/tool/grml.yaml": "exec: a\n",
+			"b/tool/grml.yaml": "exec: b\n",
+		}, "grml.yaml:3:27: include 'b/*/grml.yaml': 'b/tool/grml.yaml': command 'tool' already exists"},
+		{map[string]string{
+			"grml.yaml":     "version: 3\nproject: p\nincludes: api/grml.yaml\ncommands:\n    api:\n        exec: a\n",
+			"api/grml.yaml": "exec: b\n",
+		}, "command 'api' already exists"},
+		{map[string]string{
+			"grml.yaml":  "version: 3\nproject: p\nincludes: '*.yaml'\n",
+			"other.yaml": "exec: b\n",
+		}, "'grml.yaml' is not in a subdirectory"},
+		{map[string]string{
+			"grml.yaml": "version: 3\nproject: p\nincludes: /etc/*.yaml\n",
+		}, "must be relative to the root directory"},
+	} {
+		_, err := parseFilesErr(t, c.files)
+		expectErr(t, err, c.err)
+	}
+}
+
+func TestUseTemplates(t *testing.T) {
+	m := parseFiles(t, map[string]string{"grml.yaml": `version: 3
+project: p
+templates:
+    service:
+        params: [NAME, PORT]
+        help: the ${NAME} service
+        env:
+            ADDR: ":${PORT}"
+        options:
+            log:
+                values: [info, "${NAME}-debug"]
+                default: info
+        hooks:
+            before_each: echo start ${NAME} ${GRML_COMMAND}
+        commands:
+            run:
+                help: run ${NAME}
+                deps: [.build]
+                exec: ./${NAME} -port ${PORT}
+            build:
+                help: build ${NAME}
+                commands:
+                    docker:
+                        help: build the ${NAME} image
+                        exec: docker build -t ${NAME} .
+commands:
+    api:
+        use: {template: service, with: {NAME: api, PORT: "8080"}}
+        commands:
+            run:
+                help: run the api locally
+    web:
+        use: {template: service, with: {NAME: web, PORT: "80"}}
+`})
+
+	api, web := m.Commands["api"], m.Commands["web"]
+	if api.Help != "the api service" || web.Help != "the web service" {
+		t.Errorf("help %q, %q", api.Help, web.Help)
+	}
+	if len(api.Env) != 1 || api.Env[0] != (EnvVar{Key: "ADDR", Value: ":8080"}) {
+		t.Errorf("env %v", api.Env)
+	}
+	if h := web.Hooks.BeforeEach; h != "echo start web ${GRML_COMMAND}" {
+		t.Errorf("hook %q", h)
+	}
+	values := web.Options["log"].(map[string]interface{})["values"].([]interface{})
+	if len(values) != 2 || values[1] != "web-debug" {
+		t.Errorf("option values %v", values)
+	}
+
+	// The command's own sub commands take precedence.
+	if h := api.Commands["run"].Help; h != "run the api locally" {
+		t.Errorf("api.run help %q", h)
+	}
+	if c := web.Commands["run"]; c.Exec != "./web -port 80" || c.Deps[0] != ".build" {
+		t.Errorf("web.run %+v", c)
+	}
+	if c := web.Commands["build"].Commands["docker"]; c.Exec != "docker build -t web ." {
+		t.Errorf("web.build.docker exec %q", c.Exec)
+	}
+
+	// Instances don't share the template's values.
+	if api.Commands["build"] == web.Commands["build"] || m.Templates["service"].Help != "the ${NAME} service" {
+		t.Error("template modified by its instances")
+	}
+}
+
+func TestUseTemplatesErrors(t *testing.T) {
+	const templates = `version: 3
+project: p
+templates:
+    t:
+        params: [NAME]
+        exec: echo ${NAME}
+    any:
+        exec: echo ${NAME}
+commands:
+`
+	for _, c := range []struct {
+		commands, err string
+	}{
+		{"    c:\n        use: {template: missing}\n", "grml.yaml:11:15: command 'c': template 'missing' does not exist"},
+		{"    c:\n        use: {template: t}\n", "command 'c': template 't': missing param 'NAME'"},
+		{"    c:\n        use: {template: t, with: {NAME: a, NAEM: b}}\n", "grml.yaml:11:28: command 'c': template 't': unknown param 'NAEM'"},
+		{"    c:\n        commands:\n            d:\n                use: {template: t, with: {}}\n", "command 'c.d': template 't': missing param 'NAME'"},
+		{"    c:\n        params: [NAME]\n        exec: echo\n", "grml.yaml:11:9: command 'c': params are only supported in templates"},
+	} {
+		_, err := parseFilesErr(t, map[string]string{"grml.yaml": templates + c.commands})
+		expectErr(t, err, c.err)
+	}
+
+	// Templates without declared params take any.
+	m := parseFiles(t, map[string]string{"grml.yaml": templates + "    c:\n        use: {template: any, with: {OTHER: a}}\n"})
+	if m.Commands["c"].Exec != "echo ${NAME}" {
+		t.Errorf("exec %q", m.Commands["c"].Exec)
+	}
+}
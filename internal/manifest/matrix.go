@@ -0,0 +1,257 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matrix declares variants of a command, one for each combination of
+// the variables' values except the excluded ones. Names replaces values
+// in the variant names.
+//
+//	matrix:
+//	    GOOS: [linux, windows]
+//	    GOARCH: [amd64, arm64]
+//	    exclude:
+//	        - {GOOS: windows, GOARCH: arm64}
+//	    names: {windows: win}
+type Matrix struct {
+	Vars    []MatrixVar
+	Exclude []map[string]string
+	Names   map[string]string
+}
+
+// MatrixVar is a matrix variable with its values in declaration order.
+type MatrixVar struct {
+	Name   string
+	Values []string
+}
+
+func (m *Matrix) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: cannot unmarshal %s into manifest.Matrix", node.Line, node.ShortTag()),
+		}}
+	}
+
+	var errs []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "exclude":
+			if err := value.Decode(&m.Exclude); err != nil {
+				return err
+			}
+			continue
+		case "names":
+			if err := value.Decode(&m.Names); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v := MatrixVar{Name: key.Value}
+		if err := value.Decode(&v.Values); err != nil {
+			return err
+		} else if len(v.Values) == 0 {
+			errs = append(errs, fmt.Sprintf("line %d: matrix %s: empty list", key.Line, key.Value))
+		}
+		for _, s := range v.Values {
+			if s == "" || strings.ContainsAny(s, ". \t") {
+				errs = append(errs, fmt.Sprintf("line %d: matrix %s: invalid value '%s': must not be empty or contain dots or spaces", value.Line, key.Value, s))
+			}
+		}
+		m.Vars = append(m.Vars, v)
+	}
+	if len(m.Vars) == 0 {
+		errs = append(errs, fmt.Sprintf("line %d: matrix: no variables", node.Line))
+	}
+
+	for _, e := range m.Exclude {
+		for k := range e {
+			if !m.has(k) {
+				errs = append(errs, fmt.Sprintf("line %d: matrix exclude: unknown variable '%s'", node.Line, k))
+			}
+		}
+	}
+	for value, name := range m.Names {
+		if !m.hasValue(value) {
+			errs = append(errs, fmt.Sprintf("line %d: matrix names: unknown value '%s'", node.Line, value))
+		} else if name == "" || strings.ContainsAny(name, ". \t") {
+			errs = append(errs, fmt.Sprintf("line %d: matrix names: invalid name '%s': must not be empty or contain dots or spaces", node.Line, name))
+		}
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+	return nil
+}
+
+func (m *Matrix) has(name string) bool {
+	for _, v := range m.Vars {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasValue returns true if a variable declares the value.
+func (m *Matrix) hasValue(value string) bool {
+	for _, v := range m.Vars {
+		for _, s := range v.Values {
+			if s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatrixVariant is a single combination of matrix values.
+type MatrixVariant struct {
+	Name string // The values or their names joined with '-', e.g. 'linux-amd64'.
+	Env  Env    // The matrix variables in declaration order.
+}
+
+// Variants returns all combinations of the matrix values that are not
+// excluded. The first variable varies slowest.
+func (m *Matrix) Variants() []MatrixVariant {
+	variants := []MatrixVariant{{}}
+	for _, v := range m.Vars {
+		var next []MatrixVariant
+		for _, prev := range variants {
+			for _, value := range v.Values {
+				env := append(append(Env{}, prev.Env...), EnvVar{Key: v.Name, Value: value})
+				next = append(next, MatrixVariant{Env: env})
+			}
+		}
+		variants = next
+	}
+
+	result := variants[:0]
+	for _, mv := range variants {
+		if m.excluded(mv) {
+			continue
+		}
+		values := make([]string, len(mv.Env))
+		for i, e := range mv.Env {
+			values[i] = e.Value
+			if name, ok := m.Names[e.Value]; ok {
+				values[i] = name
+			}
+		}
+		mv.Name = strings.Join(values, "-")
+		result = append(result, mv)
+	}
+	return result
+}
+
+// excluded returns true if the variant matches all values of an exclude
+// entry.
+func (m *Matrix) excluded(mv MatrixVariant) bool {
+Loop:
+	for _, e := range m.Exclude {
+		for _, v := range mv.Env {
+			if value, ok := e[v.Key]; ok && value != v.Value {
+				continue Loop
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ExpandMatrix returns a copy of the command at path with a generated sub
+// command for each matrix variant. The variants run the command's exec
+// with the matrix values exported, the command itself depends on all of
+// them. The variants inherit the env and imports of the command like any
+// sub command. Returns the command unchanged if it declares no matrix.
+func (c *Command) ExpandMatrix(path string) (*Command, error) {
+	if c.Matrix == nil {
+		return c, nil
+	}
+
+	n := c.copy()
+	n.Matrix = nil
+	n.Exec = ""
+	n.Commands = make(Commands, len(c.Commands))
+	for k, v := range c.Commands {
+		n.Commands[k] = v
+	}
+
+	variants := make(map[string]bool)
+	for _, mv := range c.Matrix.Variants() {
+		if variants[mv.Name] {
+			return nil, c.PosOf("matrix").Errorf("command '%s': matrix variant '%s' is generated twice", path, mv.Name)
+		} else if _, ok := n.Commands[mv.Name]; ok {
+			return nil, c.PosOf("matrix").Errorf("command '%s': matrix variant '%s' collides with a sub command", path, mv.Name)
+		}
+		variants[mv.Name] = true
+
+		v := c.copy()
+		v.Matrix = nil
+		v.Alias = nil
+		v.Fanout = nil
+		v.Commands = nil
+		v.Options = nil // Declared by the parent scope.
+		v.Functions = nil
+		v.Use = nil
+		v.Hooks = nil  // Run around the variants by the parent scope.
+		v.Import = nil // Sourced by the parent scope.
+
+		vars := make([]string, len(mv.Env))
+		for i, e := range mv.Env {
+			vars[i] = e.Key + "=" + e.Value
+		}
+		v.Help = fmt.Sprintf("%s (%s)", c.Help, strings.Join(vars, " "))
+
+		// The env of the command is the parent scope's.
+		v.Env = append(Env{}, mv.Env...)
+
+		// Dep paths relative to the command stay relative to it.
+		v.Deps = make([]string, len(c.Deps))
+		for i, d := range c.Deps {
+			if strings.HasPrefix(d, ".") {
+				d = path + d
+			}
+			v.Deps[i] = d
+		}
+
+		n.Commands[mv.Name] = v
+		n.Deps = append(n.Deps, "."+mv.Name)
+	}
+	return n, nil
+}
+
+// copy returns a shallow copy of the command with its own field
+// positions and deps.
+func (c *Command) copy() *Command {
+	n := *c
+	n.fields = make(map[string]Pos, len(c.fields))
+	for k, p := range c.fields {
+		n.fields[k] = p
+	}
+	n.Deps = append([]string(nil), c.Deps...)
+	return &n
+}
@@ -0,0 +1,124 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	m := parseFiles(t, map[string]string{"grml.yaml": `version: 3
+project: p
+commands:
+    build:
+        help: cross compile
+        alias: [b]
+        env:
+            OUT: bin
+        import: [build.sh]
+        deps: [.generate, lint]
+        matrix:
+            GOOS: [linux, windows, darwin]
+            GOARCH: [amd64, arm64]
+            exclude:
+                - {GOOS: windows, GOARCH: arm64}
+                - {GOOS: darwin}
+            names: {windows: win}
+        exec: go build
+        commands:
+            generate:
+                help: generate
+                exec: go generate
+    lint:
+        help: lint
+        exec: go vet
+`})
+	c, err := m.Commands["build"].ExpandMatrix("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".generate", "lint", ".linux-amd64", ".linux-arm64", ".win-amd64"}
+	if !reflect.DeepEqual(c.Deps, want) {
+		t.Errorf("deps %v, want %v", c.Deps, want)
+	}
+	if c.Exec != "" || c.Matrix != nil || len(c.Commands) != 4 {
+		t.Errorf("expanded command %+v", c)
+	}
+
+	v := c.Commands["win-amd64"]
+	if v == nil {
+		t.Fatalf("no variant win-amd64 in %v", c.Commands)
+	}
+	if v.Help != "cross compile (GOOS=windows GOARCH=amd64)" || v.Exec != "go build" {
+		t.Errorf("variant %+v", v)
+	}
+	// The relative deps stay relative to the command.
+	if want := []string{"build.generate", "lint"}; !reflect.DeepEqual(v.Deps, want) {
+		t.Errorf("variant deps %v, want %v", v.Deps, want)
+	}
+	// The env and imports are inherited from the command.
+	if want := (Env{{Key: "GOOS", Value: "windows"}, {Key: "GOARCH", Value: "amd64"}}); !reflect.DeepEqual(v.Env, want) {
+		t.Errorf("variant env %v, want %v", v.Env, want)
+	}
+	if v.Import != nil || v.Alias != nil || v.Commands != nil {
+		t.Errorf("variant %+v", v)
+	}
+
+	// The command itself is unchanged.
+	if b := m.Commands["build"]; b.Exec != "go build" || len(b.Deps) != 2 || len(b.Commands) != 1 {
+		t.Errorf("command modified: %+v", b)
+	}
+}
+
+func TestExpandMatrixErrors(t *testing.T) {
+	for _, c := range []struct {
+		matrix, commands, err string
+	}{
+		{"{GOOS: [linux]}", "linux: {help: h, exec: e}", "grml.yaml:5:9: command 'build': matrix variant 'linux' collides with a sub command"},
+		{"{A: [a-b, a], B: [c, b-c]}", "", "command 'build': matrix variant 'a-b-c' is generated twice"},
+		{"{GOOS: [linux, windows], names: {windows: linux}}", "", "matrix variant 'linux' is generated twice"},
+	} {
+		src := "version: 3\nproject: p\ncommands:\n    build:\n        matrix: " + c.matrix + "\n        help: h\n        exec: e\n"
+		if c.commands != "" {
+			src += "        commands: {" + c.commands + "}\n"
+		}
+		m := parseFiles(t, map[string]string{"grml.yaml": src})
+		_, err := m.Commands["build"].ExpandMatrix("build")
+		expectErr(t, err, c.err)
+	}
+
+	for _, c := range []struct {
+		matrix, err string
+	}{
+		{"{GOOS: [linux], exclude: [{GOARCH: amd64}]}", "matrix exclude: unknown variable 'GOARCH'"},
+		{"{GOOS: [linux], names: {windows: win}}", "matrix names: unknown value 'windows'"},
+		{"{GOOS: [linux], names: {linux: a.b}}", "matrix names: invalid name 'a.b'"},
+		{"{GOOS: [a b]}", "matrix GOOS: invalid value 'a b'"},
+		{"{exclude: []}", "matrix: no variables"},
+	} {
+		_, err := parseFilesErr(t, map[string]string{"grml.yaml": "version: 3\nproject: p\ncommands:\n    build:\n        matrix: " + c.matrix + "\n        exec: e\n"})
+		expectErr(t, err, c.err)
+		if err != nil && !strings.HasPrefix(err.Error(), "grml.yaml") {
+			t.Errorf("error without position: %v", err)
+		}
+	}
+}
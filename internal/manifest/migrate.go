@@ -0,0 +1,435 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migrations rewrite the source of a grml file from the version of their
+// index to the next one. They are applied to the grml file and its
+// include files, which don't declare a version and share the one of the
+// grml file. Bumping the version is left to Migrate, so the compatibility
+// layer of Parse still reports the declared one.
+//
+// Version 1 pluralized some keys differently, version 2 declared env
+// variables as a list of KEY=value strings.
+var migrations = map[int]func(file string, src []byte, root *yaml.Node) ([]edit, error){
+	1: migrateV1,
+	2: migrateV2,
+}
+
+// renamedKeys maps the keys of version 1 to their current name. They are
+// renamed in the top-level mapping and in every command.
+var renamedKeys = map[string]string{
+	"aliases": "alias",
+	"depends": "deps",
+	"imports": "import",
+}
+
+// An edit replaces src[start:end] with text to upgrade a deprecated
+// construct. The warning describes it at line and column and is empty
+// for edits not worth reporting.
+type edit struct {
+	start, end int
+	text       string
+
+	warning      string
+	line, column int
+}
+
+// Migrate upgrades the grml file at path and its include files to the
+// current version in place. The files are edited textually to preserve
+// comments and ordering, and only written if all of them could be
+// upgraded. Returns the version of the grml file before the migration,
+// the paths of the rewritten files and the warnings about the deprecated
+// constructs that were rewritten.
+func Migrate(path string) (from int, files, warnings []string, err error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	file := filepath.Base(path)
+	from, err = sourceVersion(src)
+	if err != nil {
+		return 0, nil, nil, yamlError(file, err)
+	} else if from < MinVersion || from > Version {
+		return 0, nil, nil, fmt.Errorf("%s: incompatible grml version: file=%v current=%v", file, from, Version)
+	} else if from == Version {
+		return
+	}
+
+	src, warnings, err = upgrade(file, src, from)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	src, err = bumpVersion(file, src)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	m := &Manifest{}
+	if err = yaml.Unmarshal(src, m); err != nil {
+		return 0, nil, nil, yamlError(file, err)
+	}
+	m.setFile(file)
+	m.Commands.setFile(file)
+
+	// Upgrade the include files, mounted ones included.
+	rootPath := filepath.Dir(path)
+	if err = m.mountIncludes(rootPath); err != nil {
+		return 0, nil, nil, err
+	}
+	sources := map[string][]byte{path: src}
+	if err = upgradeIncludes(rootPath, m.Commands, from, sources, &warnings); err != nil {
+		return 0, nil, nil, err
+	}
+
+	for p := range sources {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	for _, p := range files {
+		var info os.FileInfo
+		info, err = os.Stat(p)
+		if err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(p, sources[p], info.Mode()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// upgradeIncludes upgrades the include files of the commands and their
+// descendants from version from. The changed sources are added to
+// sources keyed by their path and the warnings are appended to warnings.
+func upgradeIncludes(rootPath string, cmds Commands, from int, sources map[string][]byte, warnings *[]string) error {
+	for _, name := range cmds.names() {
+		cmd := cmds[name]
+		if cmd.Include != "" {
+			path := filepath.Join(rootPath, cmd.Include)
+			if _, ok := sources[path]; ok {
+				continue
+			}
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				return cmd.PosOf("include").Errorf("%v", err)
+			}
+			upgraded, w, err := upgrade(cmd.Include, src, from)
+			if err != nil {
+				return err
+			}
+			*warnings = append(*warnings, w...)
+			if string(upgraded) != string(src) {
+				sources[path] = upgraded
+			}
+
+			// Find the nested includes.
+			var c Command
+			if err = yaml.Unmarshal(upgraded, &c); err != nil {
+				return yamlError(cmd.Include, err)
+			}
+			c.setFile(cmd.Include)
+			c.Commands.setFile(cmd.Include)
+			if err = upgradeIncludes(rootPath, c.Commands, from, sources, warnings); err != nil {
+				return err
+			}
+		}
+		if err := upgradeIncludes(rootPath, cmd.Commands, from, sources, warnings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgrade rewrites the source of a grml file declaring version from, or
+// of one of its include files, to the current keys and structure. The
+// version itself is left alone. Returns the upgraded source and the
+// deprecation warnings, prefixed with their position in file.
+func upgrade(file string, src []byte, from int) ([]byte, []string, error) {
+	var warnings []string
+	for v := from; v < Version; v++ {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(src, &doc); err != nil {
+			return nil, nil, yamlError(file, err)
+		}
+		if len(doc.Content) == 0 {
+			break
+		}
+
+		edits, err := migrations[v](file, src, doc.Content[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+		for _, e := range edits {
+			if e.warning != "" {
+				pos := Pos{File: file, Line: e.line, Column: e.column}
+				warnings = append(warnings, pos.Errorf("%s", e.warning).Error())
+			}
+		}
+		src = applyEdits(src, edits)
+	}
+	return src, warnings, nil
+}
+
+// applyEdits returns src with the edits, sorted by their start and not
+// overlapping, applied.
+func applyEdits(src []byte, edits []edit) []byte {
+	out := make([]byte, 0, len(src))
+	last := 0
+	for _, e := range edits {
+		out = append(out, src[last:e.start]...)
+		out = append(out, e.text...)
+		last = e.end
+	}
+	return append(out, src[last:]...)
+}
+
+// migrateV1 renames the keys of version 1.
+func migrateV1(file string, src []byte, root *yaml.Node) (edits []edit, err error) {
+	for _, m := range scopeNodes(root) {
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			key := m.Content[i]
+			to, ok := renamedKeys[key.Value]
+			if !ok {
+				continue
+			}
+			start, end, err := scalarSpan(file, src, key)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, edit{
+				start:   start,
+				end:     end,
+				text:    to,
+				warning: fmt.Sprintf("'%s' is deprecated: use '%s'", key.Value, to),
+				line:    key.Line,
+				column:  key.Column,
+			})
+		}
+	}
+	return
+}
+
+// migrateV2 converts the env lists of version 2 to mappings.
+func migrateV2(file string, src []byte, root *yaml.Node) (edits []edit, err error) {
+	for _, m := range scopeNodes(root) {
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			key, value := m.Content[i], m.Content[i+1]
+			if key.Value != "env" || value.Kind != yaml.SequenceNode {
+				continue
+			}
+			es, err := envEdits(file, src, value)
+			if err != nil {
+				return nil, err
+			}
+			if len(es) > 0 {
+				es[0].warning = "env as a list of KEY=value is deprecated: use a mapping"
+				es[0].line, es[0].column = key.Line, key.Column
+			}
+			edits = append(edits, es...)
+		}
+	}
+	return
+}
+
+// envEdits rewrites the env sequence node to a mapping in the same style.
+func envEdits(file string, src []byte, seq *yaml.Node) ([]edit, error) {
+	entries := make([]string, len(seq.Content))
+	for i, item := range seq.Content {
+		pos := Pos{File: file, Line: item.Line, Column: item.Column}
+		if item.Kind != yaml.ScalarNode {
+			return nil, pos.Errorf("env: expected a KEY=value string")
+		}
+		kv := strings.SplitN(item.Value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, pos.Errorf("env: '%s' is not a KEY=value string", item.Value)
+		}
+		entries[i] = quoteScalar(kv[0]) + ": " + quoteScalar(kv[1])
+	}
+
+	start := offsetOf(src, seq.Line, seq.Column)
+	if seq.Style&yaml.FlowStyle != 0 {
+		end, err := flowEnd(file, src, start, seq)
+		if err != nil {
+			return nil, err
+		}
+		return []edit{{start: start, end: end, text: "{" + strings.Join(entries, ", ") + "}"}}, nil
+	}
+
+	// Replace each '- KEY=value' item by 'KEY: value' in place.
+	edits := make([]edit, len(seq.Content))
+	for i, item := range seq.Content {
+		itemStart, end, err := scalarSpan(file, src, item)
+		if err != nil {
+			return nil, err
+		}
+		dash := itemStart - 1
+		for dash >= 0 && (src[dash] == ' ' || src[dash] == '\t') {
+			dash--
+		}
+		if dash < 0 || src[dash] != '-' {
+			return nil, Pos{File: file, Line: item.Line, Column: item.Column}.Errorf("env: failed to locate the list item")
+		}
+		edits[i] = edit{start: dash, end: end, text: entries[i]}
+	}
+	return edits, nil
+}
+
+// scopeNodes returns the top-level mapping node of a grml or include file
+// and the mapping nodes of all commands below it.
+func scopeNodes(root *yaml.Node) []*yaml.Node {
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	nodes := []*yaml.Node{root}
+	if cmds := mappingValue(root, "commands"); cmds != nil && cmds.Kind == yaml.MappingNode {
+		for i := 1; i < len(cmds.Content); i += 2 {
+			nodes = append(nodes, scopeNodes(cmds.Content[i])...)
+		}
+	}
+	return nodes
+}
+
+// quoteScalar returns s as a YAML scalar on a single line.
+func quoteScalar(s string) string {
+	data, err := yaml.Marshal(s)
+	text := strings.TrimSuffix(string(data), "\n")
+	if err != nil || strings.Contains(text, "\n") {
+		return strconv.Quote(s)
+	}
+	return text
+}
+
+// sourceVersion returns the version declared in the grml file source.
+func sourceVersion(src []byte) (int, error) {
+	var v struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(src, &v); err != nil {
+		return 0, err
+	}
+	return v.Version, nil
+}
+
+// bumpVersion sets the 'version' value of the grml file to the current one.
+func bumpVersion(file string, src []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, yamlError(file, err)
+	}
+	var node *yaml.Node
+	if len(doc.Content) > 0 {
+		node = mappingValue(doc.Content[0], "version")
+	}
+	if node == nil {
+		return nil, fmt.Errorf("%s: missing field 'version'", file)
+	}
+	start, end, err := scalarSpan(file, src, node)
+	if err != nil {
+		return nil, err
+	}
+	return applyEdits(src, []edit{{start: start, end: end, text: strconv.Itoa(Version)}}), nil
+}
+
+// offsetOf returns the byte offset of the line and column, counted in
+// runes, in src.
+func offsetOf(src []byte, line, column int) int {
+	offset := 0
+	for l := 1; l < line && offset < len(src); offset++ {
+		if src[offset] == '\n' {
+			l++
+		}
+	}
+	for c := 1; c < column && offset < len(src); c++ {
+		_, size := utf8.DecodeRune(src[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// scalarSpan returns the byte offsets of the single line scalar node's
+// source in src, including the quotes.
+func scalarSpan(file string, src []byte, node *yaml.Node) (start, end int, err error) {
+	pos := Pos{File: file, Line: node.Line, Column: node.Column}
+	start = offsetOf(src, node.Line, node.Column)
+
+	switch {
+	case node.Kind != yaml.ScalarNode:
+	case node.Style == 0:
+		end = start + len(node.Value)
+		if end <= len(src) && string(src[start:end]) == node.Value {
+			return
+		}
+	case node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0:
+		quote := src[start]
+		for i := start + 1; i < len(src) && src[i] != '\n'; i++ {
+			switch {
+			case quote == '"' && src[i] == '\\':
+				i++
+			case src[i] != quote:
+			case quote == '\'' && i+1 < len(src) && src[i+1] == '\'':
+				i++
+			default:
+				return start, i + 1, nil
+			}
+		}
+	}
+	return 0, 0, pos.Errorf("failed to locate '%s': expected a single line scalar", node.Value)
+}
+
+// flowEnd returns the byte offset after the flow collection node starting
+// at start.
+func flowEnd(file string, src []byte, start int, node *yaml.Node) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, Pos{File: file, Line: node.Line, Column: node.Column}.Errorf("failed to locate the end of the flow collection")
+}
@@ -0,0 +1,198 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// migrateFixtures are grml files of the deprecated versions, keyed by the
+// version, with their upgraded sources and the deprecation warnings.
+var migrateFixtures = map[int]struct {
+	files, upgraded map[string]string
+	warnings        []string
+}{
+	1: {
+		files: map[string]string{
+			"grml.yaml": `# The project.
+version: 1
+project: p
+imports:
+    - lib.sh
+env:
+    - BIN=bin # The output directory.
+    - 'FLAGS=-v -x'
+includes: tools/*/grml.yaml
+commands:
+    build:
+        aliases: [b]
+        exec: go build
+    test:
+        include: test/grml.yaml
+`,
+			"test/grml.yaml": `help: run the tests
+env: [DIR=test, "MSG=a: b"]
+commands:
+    unit:
+        exec: go test
+    all:
+        depends:
+            - unit
+`,
+			"tools/lint/grml.yaml": `aliases: [l]
+exec: lint
+`,
+		},
+		upgraded: map[string]string{
+			"grml.yaml": `# The project.
+version: 3
+project: p
+import:
+    - lib.sh
+env:
+    BIN: bin # The output directory.
+    FLAGS: -v -x
+includes: tools/*/grml.yaml
+commands:
+    build:
+        alias: [b]
+        exec: go build
+    test:
+        include: test/grml.yaml
+`,
+			"test/grml.yaml": `help: run the tests
+env: {DIR: test, MSG: 'a: b'}
+commands:
+    unit:
+        exec: go test
+    all:
+        deps:
+            - unit
+`,
+			"tools/lint/grml.yaml": `alias: [l]
+exec: lint
+`,
+		},
+		warnings: []string{
+			"grml.yaml:2:1: grml version 1 is deprecated: run 'grml migrate' to upgrade to version 3",
+			"grml.yaml:4:1: 'imports' is deprecated: use 'import'",
+			"grml.yaml:12:9: 'aliases' is deprecated: use 'alias'",
+			"grml.yaml:6:1: env as a list of KEY=value is deprecated: use a mapping",
+			"tools/lint/grml.yaml:1:1: 'aliases' is deprecated: use 'alias'",
+			"test/grml.yaml:7:9: 'depends' is deprecated: use 'deps'",
+			"test/grml.yaml:2:1: env as a list of KEY=value is deprecated: use a mapping",
+		},
+	},
+	2: {
+		files: map[string]string{
+			"grml.yaml": `version: 2
+project: p
+env:
+    - BIN=bin
+commands:
+    build:
+        env:
+            - GOOS=linux
+        exec: go build
+`,
+		},
+		upgraded: map[string]string{
+			"grml.yaml": `version: 3
+project: p
+env:
+    BIN: bin
+commands:
+    build:
+        env:
+            GOOS: linux
+        exec: go build
+`,
+		},
+		warnings: []string{
+			"grml.yaml:1:1: grml version 2 is deprecated: run 'grml migrate' to upgrade to version 3",
+			"grml.yaml:3:1: env as a list of KEY=value is deprecated: use a mapping",
+			"grml.yaml:7:9: env as a list of KEY=value is deprecated: use a mapping",
+		},
+	},
+}
+
+func TestMigrate(t *testing.T) {
+	for version, f := range migrateFixtures {
+		dir := writeFiles(t, f.files)
+		from, files, warnings, err := Migrate(filepath.Join(dir, "grml.yaml"))
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		} else if from != version {
+			t.Errorf("version %d: migrated from %d", version, from)
+		}
+		// The warnings of the rewritten constructs, without the version.
+		if !reflect.DeepEqual(warnings, f.warnings[1:]) {
+			t.Errorf("version %d: warnings %q, want %q", version, warnings, f.warnings[1:])
+		}
+		if len(files) != len(f.upgraded) {
+			t.Errorf("version %d: rewrote %v", version, files)
+		}
+		for name, want := range f.upgraded {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != want {
+				t.Errorf("version %d: %s:\n%s\nwant:\n%s", version, name, data, want)
+			}
+		}
+
+		// The upgraded files are current and equal to the read ones.
+		if from, _, _, err = Migrate(filepath.Join(dir, "grml.yaml")); err != nil || from != Version {
+			t.Errorf("version %d: migrated again from %d: %v", version, from, err)
+		}
+		upgraded := parseFiles(t, f.upgraded)
+		if len(upgraded.Deprecations) > 0 {
+			t.Errorf("version %d: upgraded deprecations %v", version, upgraded.Deprecations)
+		}
+		compat := parseFiles(t, f.files)
+		if !reflect.DeepEqual(compat.Deprecations, f.warnings) {
+			t.Errorf("version %d: deprecations:\n%q\nwant:\n%q", version, compat.Deprecations, f.warnings)
+		}
+		if !reflect.DeepEqual(compat.Env, upgraded.Env) || !reflect.DeepEqual(compat.Import, upgraded.Import) ||
+			compat.Commands.Count() != upgraded.Commands.Count() {
+			t.Errorf("version %d: read %+v, want %+v", version, compat, upgraded)
+		}
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	for _, c := range []struct {
+		src, err string
+	}{
+		{"version: 0\nproject: p\n", "incompatible grml version: file=0"},
+		{"version: 2\nproject: p\nenv: [BIN]\n", "grml.yaml:3:7: env: 'BIN' is not a KEY=value string"},
+		{"version: 2\nproject: p\nenv:\n    - |\n        A=1\n", "grml.yaml:4:7: failed to locate"},
+	} {
+		dir := writeFiles(t, map[string]string{"grml.yaml": c.src})
+		_, _, _, err := Migrate(filepath.Join(dir, "grml.yaml"))
+		expectErr(t, err, c.err)
+		if data, _ := os.ReadFile(filepath.Join(dir, "grml.yaml")); string(data) != c.src {
+			t.Errorf("%q: rewritten to %q", c.src, data)
+		}
+	}
+}
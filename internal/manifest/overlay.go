@@ -0,0 +1,200 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LocalPath returns the path of the local override file of the grml file
+// at path, e.g. 'grml.local.yaml' for 'grml.yaml'.
+func LocalPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".local" + ext
+}
+
+// merge merges the overlay o into m. Scalars and hooks declared by the
+// overlay replace those of m, env variables replace the value of the same key in
+// place or are appended, imports and includes are appended, options,
+// functions, profiles and templates are replaced by name and commands are merged
+// recursively.
+func (m *Manifest) merge(o *Manifest) {
+	if o.Version != 0 {
+		m.Version = o.Version
+	}
+	if o.Project != "" {
+		m.Project = o.Project
+	}
+	if o.Interpreter != "" {
+		m.Interpreter = o.Interpreter
+	}
+	m.Env = m.Env.merge(o.Env)
+	m.Options = mergeOptions(m.Options, o.Options)
+	for name, p := range o.Profiles {
+		if m.Profiles == nil {
+			m.Profiles = make(map[string]Profile)
+		}
+		m.Profiles[name] = p
+	}
+	m.Import = appendUnique(m.Import, o.Import)
+	m.Functions = mergeFunctions(m.Functions, o.Functions)
+	m.Hooks = m.Hooks.merge(o.Hooks)
+	m.Includes = appendUnique(m.Includes, o.Includes)
+	for name, t := range o.Templates {
+		if m.Templates == nil {
+			m.Templates = make(Commands)
+		}
+		m.Templates[name] = t
+	}
+	m.Commands = m.Commands.merge(o.Commands)
+	m.origin.merge(&o.origin)
+	m.Deprecations = append(m.Deprecations, o.Deprecations...)
+}
+
+// merge merges the overlay commands o into cs and returns the result.
+func (cs Commands) merge(o Commands) Commands {
+	if len(o) > 0 && cs == nil {
+		cs = make(Commands, len(o))
+	}
+	for name, oc := range o {
+		if c, ok := cs[name]; ok && oc != nil {
+			c.merge(oc)
+		} else {
+			cs[name] = oc
+		}
+	}
+	return cs
+}
+
+// merge merges the overlay command o into c. Fields declared by the
+// overlay replace those of c, except for env, options, functions,
+// imports and sub commands, which are merged like the manifest's.
+func (c *Command) merge(o *Command) {
+	if len(o.Alias) > 0 {
+		c.Alias = o.Alias
+	}
+	if o.Help != "" {
+		c.Help = o.Help
+	}
+	if o.Description != "" {
+		c.Description = o.Description
+	}
+	if o.Group != "" {
+		c.Group = o.Group
+	}
+	c.Hidden = c.Hidden || o.Hidden
+	c.Internal = c.Internal || o.Internal
+	if len(o.Args) > 0 {
+		c.Args = o.Args
+	}
+	c.Env = c.Env.merge(o.Env)
+	c.Options = mergeOptions(c.Options, o.Options)
+	c.Import = appendUnique(c.Import, o.Import)
+	c.Functions = mergeFunctions(c.Functions, o.Functions)
+	c.Hooks = c.Hooks.merge(o.Hooks)
+	if len(o.Deps) > 0 {
+		c.Deps = o.Deps
+	}
+	if o.Fanout != nil {
+		c.Fanout = o.Fanout
+	}
+	if o.Use != nil {
+		c.Use = o.Use
+	}
+	if len(o.Params) > 0 {
+		c.Params = o.Params
+	}
+	if o.Matrix != nil {
+		c.Matrix = o.Matrix
+	}
+	if o.Exec != "" {
+		c.Exec = o.Exec
+	}
+	if o.Include != "" {
+		c.Include = o.Include
+	}
+	c.Commands = c.Commands.merge(o.Commands)
+	c.origin.merge(&o.origin)
+}
+
+// merge replaces the values of variables declared by o in place and
+// appends the new ones.
+func (e Env) merge(o Env) Env {
+Loop:
+	for _, ov := range o {
+		for i, v := range e {
+			if v.Key == ov.Key {
+				e[i].Value = ov.Value
+				continue Loop
+			}
+		}
+		e = append(e, ov)
+	}
+	return e
+}
+
+// merge takes over the field positions of the overlay.
+func (o *origin) merge(overlay *origin) {
+	if o.fields == nil {
+		o.fields = make(map[string]Pos)
+	}
+	for k, p := range overlay.fields {
+		o.fields[k] = p
+	}
+}
+
+func mergeOptions(opts, o map[string]interface{}) map[string]interface{} {
+	if len(o) > 0 && opts == nil {
+		opts = make(map[string]interface{}, len(o))
+	}
+	for k, v := range o {
+		opts[k] = v
+	}
+	return opts
+}
+
+// mergeFunctions returns the functions of fns with those of o replacing
+// them by name.
+func mergeFunctions(fns, o map[string]string) map[string]string {
+	if len(o) == 0 {
+		return fns
+	}
+	n := make(map[string]string, len(fns)+len(o))
+	for k, v := range fns {
+		n[k] = v
+	}
+	for k, v := range o {
+		n[k] = v
+	}
+	return n
+}
+
+func appendUnique(l, o []string) []string {
+Loop:
+	for _, s := range o {
+		for _, v := range l {
+			if v == s {
+				continue Loop
+			}
+		}
+		l = append(l, s)
+	}
+	return l
+}
@@ -0,0 +1,147 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	m := parseFiles(t, map[string]string{
+		"grml.yaml": `version: 3
+project: p
+interpreter: sh
+env:
+    A: a
+    B: ${A}-b
+options:
+    debug: false
+    jobs: {type: int, default: 4}
+functions:
+    greet: echo hi
+import: [a.sh, b.sh]
+includes: api/grml.yaml
+hooks:
+    before_all: echo start
+    after_all: echo done
+commands:
+    build:
+        help: build
+        alias: [b]
+        env:
+            OUT: bin
+        deps: [gen]
+        exec: go build
+        commands:
+            run:
+                help: run
+                exec: ./p
+    gen:
+        help: generate
+        exec: go generate
+`,
+		"api/grml.yaml": "help: api\nexec: echo api\n",
+		"grml.ci.yaml": `interpreter: bash
+env:
+    A: ci
+    C: c
+options:
+    jobs: {type: int, default: 2}
+import: [b.sh, c.sh]
+hooks:
+    after_all: echo ci done
+commands:
+    build:
+        help: build for ci
+        env:
+            OUT: ci
+        commands:
+            verify:
+                help: verify
+                exec: ./p -v
+    lint:
+        help: lint
+        exec: go vet
+`,
+		"grml.local.yaml": `project: local
+functions:
+    greet: echo hello
+commands:
+    build:
+        exec: go build -race
+`,
+	}, "grml.ci.yaml", "grml.local.yaml")
+
+	// Scalars are replaced if set, the version is kept.
+	if m.Version != 3 || m.Project != "local" || m.Interpreter != "bash" {
+		t.Errorf("version %d, project %q, interpreter %q", m.Version, m.Project, m.Interpreter)
+	}
+	// Redefined variables keep their position.
+	if want := (Env{{"A", "ci"}, {"B", "${A}-b"}, {"C", "c"}}); !reflect.DeepEqual(m.Env, want) {
+		t.Errorf("env %v, want %v", m.Env, want)
+	}
+	if m.Options["debug"] != false || m.Options["jobs"].(map[string]interface{})["default"] != 2 {
+		t.Errorf("options %v", m.Options)
+	}
+	if m.Functions["greet"] != "echo hello" {
+		t.Errorf("functions %v", m.Functions)
+	}
+	if want := []string{"a.sh", "b.sh", "c.sh"}; !reflect.DeepEqual(m.Import, want) {
+		t.Errorf("import %v, want %v", m.Import, want)
+	}
+	if want := (Hooks{BeforeAll: "echo start", AfterAll: "echo ci done"}); *m.Hooks != want {
+		t.Errorf("hooks %+v, want %+v", *m.Hooks, want)
+	}
+
+	// Commands merge recursively.
+	b := m.Commands["build"]
+	if b.Help != "build for ci" || b.Exec != "go build -race" || b.Alias[0] != "b" || b.Deps[0] != "gen" {
+		t.Errorf("build %+v", b)
+	}
+	if want := (Env{{"OUT", "ci"}}); !reflect.DeepEqual(b.Env, want) {
+		t.Errorf("build env %v, want %v", b.Env, want)
+	}
+	if b.Commands["run"] == nil || b.Commands["verify"] == nil || m.Commands["lint"] == nil || m.Commands["api"] == nil {
+		t.Errorf("commands %v, build commands %v", m.Commands, b.Commands)
+	}
+
+	// Positions point to the overlay declaring the field.
+	if p := b.PosOf("help"); p.File != "grml.ci.yaml" {
+		t.Errorf("build help declared at %v", p)
+	}
+	if p := b.PosOf("alias"); p.File != "grml.yaml" {
+		t.Errorf("build alias declared at %v", p)
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	// Overlays are validated after the merge.
+	_, err := parseFilesErr(t, map[string]string{
+		"grml.yaml":    "version: 3\nproject: p\n",
+		"grml.ci.yaml": "version: 9\n",
+	}, "grml.ci.yaml")
+	expectErr(t, err, "grml.ci.yaml:1:1: incompatible grml version: file=9")
+
+	_, err = parseFilesErr(t, map[string]string{
+		"grml.yaml":    "version: 3\nproject: p\n",
+		"grml.ci.yaml": "commands:\n    build:\n        exe: go build\n",
+	}, "grml.ci.yaml")
+	expectErr(t, err, "grml.ci.yaml:3:9: field exe not found")
+}
@@ -0,0 +1,200 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Pos is the origin of a manifest node. File is relative to the
+// manifest's directory.
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IsValid returns true if the position was recorded.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Errorf returns an error prefixed with the position.
+func (p Pos) Errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if s := p.String(); s != "" {
+		msg = s + ": " + msg
+	}
+	return errors.New(msg)
+}
+
+// origin records where a manifest mapping and each of its fields were
+// declared.
+type origin struct {
+	// Pos of the mapping itself. For commands this is their name.
+	Pos Pos
+
+	// fields holds the positions of the mapping's keys ('deps'), their
+	// sequence items ('deps.1') and their mapping keys ('env.DESTBIN').
+	fields map[string]Pos
+}
+
+// PosOf returns the position of the field addressed by keys, e.g.
+// PosOf("deps", 1). Falls back to the closest recorded parent field and
+// finally to the position of the mapping itself.
+func (o *origin) PosOf(keys ...interface{}) Pos {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%v", k)
+	}
+	for n := len(s); n > 0; n-- {
+		if p, ok := o.fields[strings.Join(s[:n], ".")]; ok {
+			return p
+		}
+	}
+	return o.Pos
+}
+
+// record stores the positions of the mapping node's fields. Previously
+// recorded fields are overwritten, e.g. when an include file redefines
+// a field of its parent declaration.
+func (o *origin) record(node *yaml.Node) {
+	if o.fields == nil {
+		o.fields = make(map[string]Pos)
+	}
+	if !o.Pos.IsValid() {
+		o.Pos = nodePos(node)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		o.fields[key.Value] = nodePos(key)
+
+		switch value.Kind {
+		case yaml.SequenceNode:
+			for j, item := range value.Content {
+				o.fields[fmt.Sprintf("%s.%d", key.Value, j)] = nodePos(item)
+			}
+		case yaml.MappingNode:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				o.fields[key.Value+"."+value.Content[j].Value] = nodePos(value.Content[j])
+			}
+		}
+	}
+}
+
+// setFile sets the file of all positions without one.
+func (o *origin) setFile(file string) {
+	if o.Pos.File == "" {
+		o.Pos.File = file
+	}
+	for k, p := range o.fields {
+		if p.File == "" {
+			p.File = file
+			o.fields[k] = p
+		}
+	}
+}
+
+func nodePos(node *yaml.Node) Pos {
+	return Pos{Line: node.Line, Column: node.Column}
+}
+
+// decodeStrict decodes the mapping node into v, a pointer to a struct,
+// and records the field positions. Unlike the decoder's KnownFields
+// option, unknown fields are also reported inside custom unmarshalers.
+func decodeStrict(node *yaml.Node, o *origin, v interface{}, typeName string) error {
+	if node.Kind != yaml.MappingNode {
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: cannot unmarshal %s into %s", node.Line, node.ShortTag(), typeName),
+		}}
+	}
+	o.record(node)
+
+	// Collect the known field names from the struct tags.
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	var errs []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !known[key.Value] && key.Tag != "!!merge" {
+			errs = append(errs, fmt.Sprintf("line %d:%d: field %s not found in type %s", key.Line, key.Column, key.Value, typeName))
+		}
+	}
+
+	err := node.Decode(v)
+	if te, ok := err.(*yaml.TypeError); ok {
+		errs = append(errs, te.Errors...)
+	} else if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+	return nil
+}
+
+// Env is an ordered list of env variables.
+type Env []EnvVar
+
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+func (e *Env) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: cannot unmarshal %s into env", node.Line, node.ShortTag()),
+		}}
+	}
+
+	var errs []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			errs = append(errs, fmt.Sprintf("line %d: env %s: expected a scalar value", value.Line, key.Value))
+			continue
+		}
+		*e = append(*e, EnvVar{Key: key.Value, Value: value.Value})
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+	return nil
+}
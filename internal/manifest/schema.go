@@ -0,0 +1,224 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const schemaURL = "http://json-schema.org/draft-07/schema#"
+
+// fieldDescriptions are shown by editors when completing a field. The
+// fields themselves are derived from the Manifest and Command types.
+var fieldDescriptions = map[string]string{
+	"Manifest.version":     fmt.Sprintf("grml file format version, must be %d", Version),
+	"Manifest.project":     "project name, available as ${PROJECT}",
+	"Manifest.env":         "env variables in declaration order, may reference earlier ones with ${VAR}",
+	"Manifest.options":     "options visible to all commands",
+	"Manifest.profiles":    "named sets of option values keyed by [command.]name",
+	"Manifest.interpreter": "shell used to run exec bodies",
+	"Manifest.import":      "shell files sourced before every exec body, relative to ROOT",
+	"Manifest.functions":   "shell functions by name, defined before every exec body",
+	"Manifest.hooks":       "shell bodies run around every command",
+	"Manifest.includes":    "globs of subgrml files, each mounted as a command named after its directory",
+	"Manifest.templates":   "command skeletons with ${param} placeholders, instantiated with 'use'",
+	"Manifest.commands":    "commands by name",
+	"Command.alias":        "alternative names of the command",
+	"Command.help":         "help text, may reference env variables with ${VAR}",
+	"Command.description":  "long help shown by 'help <command>', may reference env variables with ${VAR}",
+	"Command.group":        "section of the command in the help output",
+	"Command.hidden":       "omit the command from help and completion, it can still be run",
+	"Command.internal":     "only run the command as a dependency, never directly",
+	"Command.args":         "names of the positional args, exported as env variables",
+	"Command.env":          "env variables scoped to this command and its descendants",
+	"Command.options":      "options scoped to this command and its descendants",
+	"Command.import":       "shell files sourced before exec for this command and its descendants",
+	"Command.functions":    "shell functions by name, defined before exec for this command and its descendants",
+	"Command.hooks":        "shell bodies run around this command and its descendants",
+	"Command.deps":         "commands run before this one: absolute paths, '.sub' or '~.name'",
+	"Command.fanout":       "command run in every included subtree defining it, before exec",
+	"Command.use":          "template instantiated as this command, fields declared by the command take precedence",
+	"Command.params":       "names of the ${param} placeholders of a template, each required in 'use.with'",
+	"Command.matrix":       "variables with lists of values, expanded into a sub command per combination",
+	"Command.exec":         "shell body to run",
+	"Command.include":      "subgrml file declaring the fields of this command",
+	"Command.commands":     "sub commands by name",
+	"Fanout.command":       "path of the command relative to each include point",
+	"Fanout.parallel":      "run the commands in parallel",
+	"Hooks.before_all":     "run once before the first command of the scope, GRML_COMMAND is set",
+	"Hooks.before_each":    "run before each command of the scope, GRML_COMMAND is set",
+	"Hooks.after_each":     "run after each command of the scope, GRML_STATUS holds its exit status",
+	"Hooks.after_all":      "run once after the last command of the scope, GRML_STATUS holds the exit status of the run",
+	"Use.template":         "name of the template",
+	"Use.with":             "values of the template's ${param} placeholders",
+}
+
+// Schema returns the JSON Schema of the grml file. If include is true,
+// the schema of an included subgrml file is returned instead.
+func Schema(include bool) ([]byte, error) {
+	s := map[string]interface{}{
+		"$schema": schemaURL,
+		"definitions": map[string]interface{}{
+			"command": typeSchema(reflect.TypeOf(Command{})),
+			"option":  optionSchema(),
+		},
+	}
+	if include {
+		s["title"] = "grml include file"
+		s["$ref"] = "#/definitions/command"
+	} else {
+		s["title"] = "grml file"
+		for k, v := range typeSchema(reflect.TypeOf(Manifest{})) {
+			s[k] = v
+		}
+		s["required"] = []string{"version", "project"}
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// typeSchema returns the schema of the struct type t, one property per
+// yaml tagged field.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		p := valueSchema(f.Type)
+		if d, ok := fieldDescriptions[t.Name()+"."+name]; ok {
+			p["description"] = d
+		}
+		props[name] = p
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// valueSchema returns the schema of a field value of type t.
+func valueSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Env{}):
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": []string{"string", "number", "boolean", "null"}},
+		}
+	case reflect.TypeOf(StringList{}):
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}
+	case reflect.TypeOf(&Fanout{}):
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				typeSchema(reflect.TypeOf(Fanout{})),
+			},
+		}
+	case reflect.TypeOf(&Matrix{}):
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"exclude": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"type": "object"},
+				},
+				"names": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+			},
+			"additionalProperties": map[string]interface{}{"type": "array", "minItems": 1},
+		}
+	case reflect.TypeOf(Commands{}):
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"$ref": "#/definitions/command"},
+		}
+	case reflect.TypeOf(map[string]interface{}{}):
+		// Option declarations.
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"$ref": "#/definitions/option"},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": valueSchema(t.Elem())}
+	case reflect.Map:
+		// Profiles.
+		return map[string]interface{}{"type": "object", "additionalProperties": valueSchema(t.Elem())}
+	case reflect.Ptr:
+		return valueSchema(t.Elem())
+	case reflect.Struct:
+		return typeSchema(t)
+	}
+	// Free-form values.
+	return map[string]interface{}{}
+}
+
+// optionSchema returns the schema of an option declaration in short or
+// long form. Option declarations are parsed by the options package.
+func optionSchema() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	integer := map[string]interface{}{"type": "integer"}
+	list := map[string]interface{}{"type": "array"}
+	strs := map[string]interface{}{"type": "array", "items": str}
+
+	return map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"type": []string{"boolean", "string", "integer"}},
+			map[string]interface{}{"type": "array", "minItems": 1},
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"type":      map[string]interface{}{"enum": []string{"bool", "choice", "string", "int", "multi"}},
+					"help":      str,
+					"requires":  strs,
+					"conflicts": strs,
+					"only_if":   str,
+					"values":    map[string]interface{}{"type": "object"},
+					"default":   map[string]interface{}{},
+					"options":   list,
+					"pattern":   str,
+					"min":       integer,
+					"max":       integer,
+					"separator": str,
+				},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestFieldDescriptions ensures every yaml field of the schema types is
+// described and every description belongs to a field.
+func TestFieldDescriptions(t *testing.T) {
+	fields := make(map[string]bool)
+	for _, v := range []interface{}{Manifest{}, Command{}, Hooks{}, Fanout{}, Use{}} {
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			key := typ.Name() + "." + name
+			fields[key] = true
+			if fieldDescriptions[key] == "" {
+				t.Errorf("missing field description of %s", key)
+			}
+		}
+	}
+	for key := range fieldDescriptions {
+		if !fields[key] {
+			t.Errorf("field description of unknown field %s", key)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		data, err := Schema(include)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s struct {
+			Definitions struct {
+				Command struct {
+					Properties map[string]struct {
+						Description string `json:"description"`
+					} `json:"properties"`
+				} `json:"command"`
+			} `json:"definitions"`
+		}
+		if err = json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		props := s.Definitions.Command.Properties
+		for _, name := range []string{"help", "deps", "exec", "matrix", "use"} {
+			if props[name].Description == "" {
+				t.Errorf("include=%v: property %s has no description", include, name)
+			}
+		}
+	}
+}
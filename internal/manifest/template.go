@@ -0,0 +1,280 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/desertbit/grml/internal/options"
+	"gopkg.in/yaml.v3"
+)
+
+// Use instantiates the named template. Its ${param} placeholders are
+// replaced by the values in With, other ${VAR} references are kept. If the
+// template declares its params, With must set exactly those.
+type Use struct {
+	Template string            `yaml:"template"`
+	With     map[string]string `yaml:"with"`
+}
+
+func (u *Use) UnmarshalYAML(node *yaml.Node) error {
+	type plain Use
+	var o origin
+	return decodeStrict(node, &o, (*plain)(u), "manifest.Use")
+}
+
+// useTemplates instantiates the templates used by the commands and their
+// descendants.
+func (m *Manifest) useTemplates(parentPath string, cmds Commands) error {
+	for name, c := range cmds {
+		path := name
+		if parentPath != "" {
+			path = parentPath + "." + name
+		}
+		if len(c.Params) > 0 {
+			return c.PosOf("params").Errorf("command '%s': params are only supported in templates", path)
+		}
+		if c.Use != nil {
+			t, ok := m.Templates[c.Use.Template]
+			if !ok {
+				return c.PosOf("use", "template").Errorf("command '%s': template '%s' does not exist", path, c.Use.Template)
+			} else if t.Use != nil || t.Include != "" {
+				return t.Pos.Errorf("template '%s': use and include are not supported in templates", c.Use.Template)
+			}
+			if err := t.checkParams(c.Use.With); err != nil {
+				return c.PosOf("use", "with").Errorf("command '%s': template '%s': %v", path, c.Use.Template, err)
+			}
+			c.apply(t.instantiate(c.Use.With))
+		}
+		if err := m.useTemplates(path, c.Commands); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParams ensures with sets exactly the declared params of the
+// template. Templates without declared params take any.
+func (c *Command) checkParams(with map[string]string) error {
+	if len(c.Params) == 0 {
+		return nil
+	}
+	declared := make(map[string]bool, len(c.Params))
+	for _, p := range c.Params {
+		declared[p] = true
+		if _, ok := with[p]; !ok {
+			return fmt.Errorf("missing param '%s'", p)
+		}
+	}
+	var unknown []string
+	for k := range with {
+		if !declared[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown param '%s'", unknown[0])
+	}
+	return nil
+}
+
+// apply merges the instantiated template t into c. Fields declared by c
+// take precedence. The env of c is evaluated after the template's, the
+// options and sub commands of both are merged.
+func (c *Command) apply(t *Command) {
+	if len(c.Alias) == 0 {
+		c.Alias = t.Alias
+	}
+	if c.Help == "" {
+		c.Help = t.Help
+	}
+	if c.Description == "" {
+		c.Description = t.Description
+	}
+	if c.Group == "" {
+		c.Group = t.Group
+	}
+	c.Hidden = c.Hidden || t.Hidden
+	c.Internal = c.Internal || t.Internal
+	if len(c.Args) == 0 {
+		c.Args = t.Args
+	}
+	c.Env = append(t.Env, c.Env...)
+	if len(t.Options) > 0 {
+		opts := t.Options
+		for k, v := range c.Options {
+			opts[k] = v
+		}
+		c.Options = opts
+	}
+	if len(c.Import) == 0 {
+		c.Import = t.Import
+	}
+	c.Functions = mergeFunctions(t.Functions, c.Functions)
+	if len(c.Deps) == 0 {
+		c.Deps = t.Deps
+	}
+	if c.Fanout == nil {
+		c.Fanout = t.Fanout
+	}
+	if c.Matrix == nil {
+		c.Matrix = t.Matrix
+	}
+	c.Hooks = t.Hooks.merge(c.Hooks)
+	if c.Exec == "" {
+		c.Exec = t.Exec
+	}
+	if len(t.Commands) > 0 {
+		if c.Commands == nil {
+			c.Commands = make(Commands)
+		}
+		for k, v := range t.Commands {
+			if _, ok := c.Commands[k]; !ok {
+				c.Commands[k] = v
+			}
+		}
+	}
+
+	// Errors in fields taken from the template point to the template.
+	if c.fields == nil {
+		c.fields = make(map[string]Pos)
+	}
+	for k, p := range t.fields {
+		if _, ok := c.fields[k]; !ok {
+			c.fields[k] = p
+		}
+	}
+}
+
+// instantiate returns a copy of the template with the ${param}
+// placeholders replaced by the values of with.
+func (c *Command) instantiate(with map[string]string) *Command {
+	pairs := make([]string, 0, 2*len(with))
+	for k, v := range with {
+		pairs = append(pairs, "${"+k+"}", v)
+	}
+	r := strings.NewReplacer(pairs...)
+	return c.replace(r)
+}
+
+func (c *Command) replace(r *strings.Replacer) *Command {
+	n := &Command{
+		Alias:       replaceAll(r, c.Alias),
+		Help:        r.Replace(c.Help),
+		Description: r.Replace(c.Description),
+		Group:       r.Replace(c.Group),
+		Hidden:      c.Hidden,
+		Internal:    c.Internal,
+		Args:        replaceAll(r, c.Args),
+		Import:      replaceAll(r, c.Import),
+		Deps:        replaceAll(r, c.Deps),
+		Exec:        r.Replace(c.Exec),
+		Include:     c.Include,
+	}
+	n.Pos = c.Pos
+	n.fields = make(map[string]Pos, len(c.fields))
+	for k, p := range c.fields {
+		n.fields[k] = p
+	}
+
+	for _, v := range c.Env {
+		n.Env = append(n.Env, EnvVar{Key: v.Key, Value: r.Replace(v.Value)})
+	}
+	if c.Options != nil {
+		n.Options = replaceValue(r, c.Options).(map[string]interface{})
+	}
+	if c.Functions != nil {
+		n.Functions = make(map[string]string, len(c.Functions))
+		for k, v := range c.Functions {
+			n.Functions[k] = r.Replace(v)
+		}
+	}
+	if c.Fanout != nil {
+		n.Fanout = &Fanout{Command: r.Replace(c.Fanout.Command), Parallel: c.Fanout.Parallel}
+	}
+	if c.Hooks != nil {
+		n.Hooks = &Hooks{
+			BeforeAll:  r.Replace(c.Hooks.BeforeAll),
+			BeforeEach: r.Replace(c.Hooks.BeforeEach),
+			AfterEach:  r.Replace(c.Hooks.AfterEach),
+			AfterAll:   r.Replace(c.Hooks.AfterAll),
+		}
+	}
+	if c.Matrix != nil {
+		n.Matrix = &Matrix{}
+		for _, v := range c.Matrix.Vars {
+			n.Matrix.Vars = append(n.Matrix.Vars, MatrixVar{Name: v.Name, Values: replaceAll(r, v.Values)})
+		}
+		for _, e := range c.Matrix.Exclude {
+			ne := make(map[string]string, len(e))
+			for k, v := range e {
+				ne[k] = r.Replace(v)
+			}
+			n.Matrix.Exclude = append(n.Matrix.Exclude, ne)
+		}
+	}
+	if c.Commands != nil {
+		n.Commands = make(Commands, len(c.Commands))
+		for k, v := range c.Commands {
+			n.Commands[k] = v.replace(r)
+		}
+	}
+	return n
+}
+
+func replaceAll(r *strings.Replacer, l []string) []string {
+	if l == nil {
+		return nil
+	}
+	n := make([]string, len(l))
+	for i, s := range l {
+		n[i] = r.Replace(s)
+	}
+	return n
+}
+
+// replaceValue returns a copy of the decoded YAML value v with all strings
+// replaced.
+func replaceValue(r *strings.Replacer, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.Replace(v)
+	case []interface{}:
+		n := make([]interface{}, len(v))
+		for i, item := range v {
+			n[i] = replaceValue(r, item)
+		}
+		return n
+	case map[string]interface{}:
+		n := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			n[k] = replaceValue(r, item)
+		}
+		return n
+	case options.OrderedMap:
+		return options.OrderedMap{
+			Keys:   v.Keys,
+			Values: replaceValue(r, v.Values).(map[string]interface{}),
+		}
+	}
+	return v
+}
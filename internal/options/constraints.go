@@ -0,0 +1,177 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package options
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraints restrict when an option may be active.
+type Constraints struct {
+	Requires  []Condition // all must hold while the option is active
+	Conflicts []Condition // none may hold while the option is active
+	OnlyIf    *Condition  // must hold while the option is active
+}
+
+// Condition is a test against an option value. Supported forms:
+//
+//	name            the option is on, see Options.Active
+//	!name           the option is off
+//	name == value   the option equals value
+//	name != value   the option does not equal value
+type Condition struct {
+	Name   string
+	Value  string
+	Negate bool
+	Bare   bool // tests if the option is on instead of its value
+}
+
+// ParseCondition parses a condition expression.
+func ParseCondition(s string) (c Condition, err error) {
+	s = strings.TrimSpace(s)
+
+	if name, value, ok := strings.Cut(s, "!="); ok {
+		c = Condition{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value), Negate: true}
+	} else if name, value, ok := strings.Cut(s, "=="); ok {
+		c = Condition{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)}
+	} else if strings.HasPrefix(s, "!") {
+		c = Condition{Name: strings.TrimSpace(s[1:]), Negate: true, Bare: true}
+	} else {
+		c = Condition{Name: s, Bare: true}
+	}
+
+	if c.Name == "" || strings.ContainsAny(c.Name, " \t!=") {
+		err = fmt.Errorf("invalid condition '%s'", s)
+	}
+	return
+}
+
+// Eval evaluates the condition against the option values in env and the
+// options that are on in active. Returns an error if the referenced
+// option does not exist.
+func (c Condition) Eval(env map[string]string, active map[string]bool) (bool, error) {
+	v, ok := env[c.Name]
+	if !ok {
+		return false, fmt.Errorf("unknown option '%s'", c.Name)
+	}
+	if c.Bare {
+		return active[c.Name] != c.Negate, nil
+	}
+	return (v == c.Value) != c.Negate, nil
+}
+
+func (c Condition) String() string {
+	switch {
+	case c.Bare && c.Negate:
+		return "!" + c.Name
+	case c.Bare:
+		return c.Name
+	case c.Negate:
+		return c.Name + " != " + c.Value
+	default:
+		return c.Name + " == " + c.Value
+	}
+}
+
+func (cs *Constraints) check(name string, on bool, env map[string]string, active map[string]bool) error {
+	// Evaluate every condition, even for inactive options, so references to
+	// unknown options are always reported.
+	eval := func(c Condition) (bool, error) {
+		ok, err := c.Eval(env, active)
+		if err != nil {
+			return false, fmt.Errorf("option '%s': %v", name, err)
+		}
+		return ok, nil
+	}
+
+	for _, c := range cs.Requires {
+		ok, err := eval(c)
+		if err != nil {
+			return err
+		} else if on && !ok {
+			return fmt.Errorf("option '%s' requires '%s'", name, c)
+		}
+	}
+	for _, c := range cs.Conflicts {
+		ok, err := eval(c)
+		if err != nil {
+			return err
+		} else if on && ok {
+			return fmt.Errorf("option '%s' conflicts with '%s'", name, c)
+		}
+	}
+	if cs.OnlyIf != nil {
+		ok, err := eval(*cs.OnlyIf)
+		if err != nil {
+			return err
+		} else if on && !ok {
+			return fmt.Errorf("option '%s' is only allowed if '%s'", name, cs.OnlyIf)
+		}
+	}
+	return nil
+}
+
+// parseConstraints returns the constraints of a long-form declaration or
+// nil if it declares none.
+func parseConstraints(d decl) (cs *Constraints, err error) {
+	c := &Constraints{}
+
+	parseList := func(key string) (conds []Condition, err error) {
+		if _, ok := d[key]; !ok {
+			return
+		}
+		l, err := d.list(key)
+		if err != nil {
+			return
+		}
+		for _, s := range l {
+			var cond Condition
+			cond, err = ParseCondition(s)
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': %v", key, err)
+			}
+			conds = append(conds, cond)
+		}
+		return
+	}
+
+	if c.Requires, err = parseList("requires"); err != nil {
+		return
+	}
+	if c.Conflicts, err = parseList("conflicts"); err != nil {
+		return
+	}
+	if _, ok := d["only_if"]; ok {
+		var s string
+		if s, err = d.string("only_if"); err != nil {
+			return
+		}
+		var cond Condition
+		if cond, err = ParseCondition(s); err != nil {
+			return nil, fmt.Errorf("field 'only_if': %v", err)
+		}
+		c.OnlyIf = &cond
+	}
+
+	if len(c.Requires) == 0 && len(c.Conflicts) == 0 && c.OnlyIf == nil {
+		return nil, nil
+	}
+	return c, nil
+}
@@ -18,7 +18,19 @@
 
 package options
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultSeparator joins the active values of a multi-select option
+	// when no explicit separator was declared.
+	DefaultSeparator = " "
+)
 
 // Options is a single scope's option set. Names are unique within an
 // Options instance but may collide across instances — each scope (root
@@ -27,60 +39,608 @@ import "fmt"
 type Options struct {
 	Bools   map[string]*Bool
 	Choices map[string]*Choice
+	Strings map[string]*String
+	Ints    map[string]*Int
+	Multis  map[string]*Multi
+
+	// Constraints keyed by option name. Only set for options declaring
+	// 'requires', 'conflicts' or 'only_if'.
+	Constraints map[string]*Constraints
+
+	// ValueEnvs keyed by option name. Only set for options declaring
+	// 'values'.
+	ValueEnvs map[string]ValueEnv
+}
+
+// ValueEnv maps option values to the env variables exported while the
+// option has that value.
+type ValueEnv map[string]map[string]string
+
+// OrderedMap is a mapping as decoded from YAML which keeps the order of
+// its keys, e.g. the 'values' of an option defining the choices.
+type OrderedMap struct {
+	Keys   []string
+	Values map[string]interface{}
 }
 
 type Bool struct {
 	Value bool
+	Help  string
 }
 
 type Choice struct {
-	Active  string
-	Options []string
-	UserSet bool // true once the user explicitly picked via 'options set'
+	Active       string
+	Options      []string
+	Descriptions map[string]string // optional per-value descriptions
+	Help         string
+	UserSet      bool // true once the user explicitly picked via 'options set'
+}
+
+// String is a free-form text option. If Pattern is set, every value
+// must match it.
+type String struct {
+	Value   string
+	Pattern *regexp.Regexp
+	Help    string
+}
+
+// Set validates and assigns v.
+func (s *String) Set(v string) error {
+	if s.Pattern != nil && !s.Pattern.MatchString(v) {
+		return fmt.Errorf("value '%s' does not match pattern '%s'", v, s.Pattern)
+	}
+	s.Value = v
+	return nil
+}
+
+// Int is an integer option with optional inclusive bounds.
+type Int struct {
+	Value int
+	Min   *int
+	Max   *int
+	Help  string
+}
+
+// Set validates and assigns v.
+func (i *Int) Set(v int) error {
+	if i.Min != nil && v < *i.Min {
+		return fmt.Errorf("value %d is less than minimum %d", v, *i.Min)
+	}
+	if i.Max != nil && v > *i.Max {
+		return fmt.Errorf("value %d is greater than maximum %d", v, *i.Max)
+	}
+	i.Value = v
+	return nil
+}
+
+// Multi is a multi-select option. The active values are exported as a
+// single env value joined by Separator.
+type Multi struct {
+	Active       []string
+	Options      []string
+	Descriptions map[string]string // optional per-value descriptions
+	Separator    string
+	Help         string
+	UserSet      bool // true once the user explicitly picked via 'options set'
+}
+
+// Set validates and assigns values. Every value must be one of Options.
+func (m *Multi) Set(values []string) error {
+	for _, v := range values {
+		if !contains(m.Options, v) {
+			return fmt.Errorf("invalid value '%s': expected one of %v", v, m.Options)
+		}
+	}
+	m.Active = values
+	return nil
+}
+
+// String returns the active values joined by the option's separator.
+func (m *Multi) String() string {
+	return strings.Join(m.Active, m.Separator)
 }
 
 func New() *Options {
 	return &Options{
 		Bools:   make(map[string]*Bool),
 		Choices: make(map[string]*Choice),
+		Strings: make(map[string]*String),
+		Ints:    make(map[string]*Int),
+		Multis:  make(map[string]*Multi),
+
+		Constraints: make(map[string]*Constraints),
+		ValueEnvs:   make(map[string]ValueEnv),
 	}
 }
 
-// Add merges raw option entries (decoded from YAML) into o. Returns an error
-// if a name collides with an option already present in this scope.
-func (o *Options) Add(raw map[string]interface{}) error {
-	for name, i := range raw {
-		if _, exists := o.Bools[name]; exists {
-			return fmt.Errorf("duplicate option: %v", name)
+// Add adds the named option as decoded from YAML to o. Returns an error
+// if the name collides with an option already present in this scope.
+//
+// Short forms: a bool becomes a check option, a list of strings a single
+// choice option, a string a free-form string option and an integer an int
+// option. A map declares an option in long form with an explicit 'type',
+// an optional 'help' text, optional constraints and for bool, choice and
+// multi-select options an optional 'values' env mapping. The type may be
+// omitted if 'values' is declared and defaults to choice.
+func (o *Options) Add(name string, i interface{}) error {
+	if o.Has(name) {
+		return fmt.Errorf("duplicate option: %v", name)
+	}
+
+	switch v := i.(type) {
+	case bool:
+		o.Bools[name] = &Bool{Value: v}
+
+	case string:
+		o.Strings[name] = &String{Value: v}
+
+	case int:
+		o.Ints[name] = &Int{Value: v}
+
+	case []interface{}:
+		if len(v) == 0 {
+			return fmt.Errorf("invalid option: %v", name)
 		}
-		if _, exists := o.Choices[name]; exists {
-			return fmt.Errorf("duplicate option: %v", name)
+		o.Choices[name] = &Choice{
+			Active:  fmt.Sprintf("%v", v[0]),
+			Options: toStrings(v),
 		}
 
-		switch v := i.(type) {
-		case bool:
-			o.Bools[name] = &Bool{Value: v}
+	default:
+		d, ok := toMap(i)
+		if !ok {
+			return fmt.Errorf("invalid option: %v: %v", name, i)
+		}
+		if err := o.addLong(name, d); err != nil {
+			return fmt.Errorf("invalid option: %v: %v", name, err)
+		}
+	}
+	return nil
+}
 
-		case []interface{}:
-			if len(v) == 0 {
-				return fmt.Errorf("invalid option: %v", name)
+// addLong adds a long-form option declaration.
+func (o *Options) addLong(name string, d decl) (err error) {
+	typ := "choice"
+	if _, ok := d["type"]; ok || d["values"] == nil {
+		if typ, err = d.string("type"); err != nil {
+			return
+		}
+	}
+
+	var help string
+	if _, ok := d["help"]; ok {
+		if help, err = d.string("help"); err != nil {
+			return
+		}
+	}
+
+	c, err := parseConstraints(d)
+	if err != nil {
+		return
+	} else if c != nil {
+		o.Constraints[name] = c
+	}
+
+	switch typ {
+	case "bool":
+		b := &Bool{Help: help}
+		if err = d.allow("type", "help", "requires", "conflicts", "only_if", "values", "default"); err != nil {
+			return
+		}
+		if v, ok := d["default"]; ok {
+			if b.Value, ok = v.(bool); !ok {
+				return fmt.Errorf("field 'default': expected a bool: %v", v)
 			}
-			list := make([]string, len(v))
-			for j, iv := range v {
-				list[j] = fmt.Sprintf("%v", iv)
+		}
+		if err = o.addValueEnv(name, d, []string{"true", "false"}); err != nil {
+			return
+		}
+		o.Bools[name] = b
+
+	case "choice":
+		ch := &Choice{Help: help}
+		if err = d.allow("type", "help", "requires", "conflicts", "only_if", "values", "default", "options"); err != nil {
+			return
+		}
+		if _, ok := d["options"]; !ok && d["values"] != nil {
+			// The choices are the mapped values in declaration order.
+			ch.Options, err = d.keys("values")
+		} else {
+			ch.Options, ch.Descriptions, err = d.values("options")
+		}
+		if err != nil {
+			return
+		}
+		if err = o.addValueEnv(name, d, ch.Options); err != nil {
+			return
+		}
+		ch.Active = ch.Options[0]
+		if v, ok := d["default"]; ok {
+			ch.Active = fmt.Sprintf("%v", v)
+			if !contains(ch.Options, ch.Active) {
+				return fmt.Errorf("default: invalid value '%s': expected one of %v", ch.Active, ch.Options)
 			}
-			o.Choices[name] = &Choice{
-				Active:  list[0],
-				Options: list,
+		}
+		o.Choices[name] = ch
+
+	case "string":
+		s := &String{Help: help}
+		if err = d.allow("type", "help", "requires", "conflicts", "only_if", "default", "pattern"); err != nil {
+			return
+		}
+		if p, ok := d["pattern"]; ok {
+			s.Pattern, err = regexp.Compile(fmt.Sprintf("%v", p))
+			if err != nil {
+				return
 			}
+		}
+		if v, ok := d["default"]; ok {
+			// Accept any scalar, e.g. 'default: 1.0' decodes as a float.
+			if err = s.Set(fmt.Sprintf("%v", v)); err != nil {
+				return fmt.Errorf("default: %v", err)
+			}
+		}
+		o.Strings[name] = s
 
-		default:
-			return fmt.Errorf("invalid option: %v: %v", name, i)
+	case "int":
+		i := &Int{Help: help}
+		if err = d.allow("type", "help", "requires", "conflicts", "only_if", "default", "min", "max"); err != nil {
+			return
+		}
+		if i.Min, err = d.optInt("min"); err != nil {
+			return
+		}
+		if i.Max, err = d.optInt("max"); err != nil {
+			return
+		}
+		if i.Min != nil && i.Max != nil && *i.Min > *i.Max {
+			return fmt.Errorf("min %d is greater than max %d", *i.Min, *i.Max)
+		}
+		var v *int
+		if v, err = d.optInt("default"); err != nil {
+			return
+		} else if v == nil && i.Min != nil {
+			v = i.Min // Default to the lower bound if unspecified.
+		}
+		if v != nil {
+			if err = i.Set(*v); err != nil {
+				return fmt.Errorf("default: %v", err)
+			}
+		}
+		o.Ints[name] = i
+
+	case "multi":
+		mo := &Multi{Separator: DefaultSeparator, Help: help}
+		if err = d.allow("type", "help", "requires", "conflicts", "only_if", "values", "default", "options", "separator"); err != nil {
+			return
+		}
+		if mo.Options, mo.Descriptions, err = d.values("options"); err != nil {
+			return
+		}
+		if err = o.addValueEnv(name, d, mo.Options); err != nil {
+			return
+		}
+		if _, ok := d["separator"]; ok {
+			if mo.Separator, err = d.string("separator"); err != nil {
+				return
+			}
+		}
+		var def []string
+		if _, ok := d["default"]; ok {
+			if def, err = d.list("default"); err != nil {
+				return
+			}
+		}
+		if err = mo.Set(def); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+		o.Multis[name] = mo
+
+	default:
+		return fmt.Errorf("unknown type '%s'", typ)
+	}
+	return
+}
+
+// addValueEnv parses the optional 'values' env mapping of the named
+// option. Every mapped value must be one of valid.
+func (o *Options) addValueEnv(name string, d decl, valid []string) error {
+	v, ok := d["values"]
+	if !ok {
+		return nil
+	}
+	m, ok := toMap(v)
+	if !ok {
+		return fmt.Errorf("field 'values': expected a map: %v", v)
+	}
+
+	ve := make(ValueEnv, len(m))
+	for mk, mv := range m {
+		value := fmt.Sprintf("%v", mk)
+		if !contains(valid, value) {
+			return fmt.Errorf("field 'values': invalid value '%s': expected one of %v", value, valid)
+		}
+
+		env := make(map[string]string)
+		if mv != nil {
+			vars, ok := toMap(mv)
+			if !ok {
+				return fmt.Errorf("field 'values': value '%s': expected a map of env variables: %v", value, mv)
+			}
+			for k, v := range vars {
+				env[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+			}
 		}
+		ve[value] = env
 	}
+	o.ValueEnvs[name] = ve
 	return nil
 }
 
+// Has returns true if an option of any type is declared under name.
+func (o *Options) Has(name string) bool {
+	if _, ok := o.Bools[name]; ok {
+		return true
+	} else if _, ok := o.Choices[name]; ok {
+		return true
+	} else if _, ok := o.Strings[name]; ok {
+		return true
+	} else if _, ok := o.Ints[name]; ok {
+		return true
+	} else if _, ok := o.Multis[name]; ok {
+		return true
+	}
+	return false
+}
+
+// Active returns true if the named option is switched on: a bool is
+// true, a string is non-empty, an int is non-zero or a multi-select has
+// at least one value. Choice options always have a value and are always
+// active, but see Check for choices with an only_if condition.
+func (o *Options) Active(name string) bool {
+	if v, ok := o.Bools[name]; ok {
+		return v.Value
+	} else if _, ok := o.Choices[name]; ok {
+		return true
+	} else if v, ok := o.Strings[name]; ok {
+		return v.Value != ""
+	} else if v, ok := o.Ints[name]; ok {
+		return v.Value != 0
+	} else if v, ok := o.Multis[name]; ok {
+		return len(v.Active) > 0
+	}
+	return false
+}
+
+// ActiveEnv returns for every option in this scope whether it's active.
+func (o *Options) ActiveEnv() map[string]bool {
+	active := make(map[string]bool)
+	for k := range o.Env() {
+		active[k] = o.Active(k)
+	}
+	return active
+}
+
+// Check validates the constraints of every active option in this scope.
+// env and active hold the option values and whether the options are
+// active, visible to this scope and keyed by name, as returned by Env and
+// ActiveEnv and layered across the scope chain.
+//
+// A choice always has a value, so unless the user set it, it's only
+// active while its only_if condition holds.
+func (o *Options) Check(env map[string]string, active map[string]bool) error {
+	names := make([]string, 0, len(o.Constraints))
+	for name := range o.Constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cs := o.Constraints[name]
+		on := o.Active(name)
+		if c, ok := o.Choices[name]; ok && !c.UserSet && cs.OnlyIf != nil {
+			var err error
+			if on, err = cs.OnlyIf.Eval(env, active); err != nil {
+				return fmt.Errorf("option '%s': %v", name, err)
+			}
+		}
+		if err := cs.check(name, on, env, active); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the option values. Declarations such as
+// patterns, bounds and constraints are shared.
+func (o *Options) Clone() *Options {
+	c := New()
+	for k, v := range o.Bools {
+		b := *v
+		c.Bools[k] = &b
+	}
+	for k, v := range o.Choices {
+		ch := *v
+		c.Choices[k] = &ch
+	}
+	for k, v := range o.Strings {
+		s := *v
+		c.Strings[k] = &s
+	}
+	for k, v := range o.Ints {
+		i := *v
+		c.Ints[k] = &i
+	}
+	for k, v := range o.Multis {
+		m := *v
+		m.Active = append([]string(nil), v.Active...)
+		c.Multis[k] = &m
+	}
+	for k, v := range o.Constraints {
+		c.Constraints[k] = v
+	}
+	for k, v := range o.ValueEnvs {
+		c.ValueEnvs[k] = v
+	}
+	return c
+}
+
+// Help returns the help text of the named option or an empty string.
+func (o *Options) Help(name string) string {
+	if v, ok := o.Bools[name]; ok {
+		return v.Help
+	} else if v, ok := o.Choices[name]; ok {
+		return v.Help
+	} else if v, ok := o.Strings[name]; ok {
+		return v.Help
+	} else if v, ok := o.Ints[name]; ok {
+		return v.Help
+	} else if v, ok := o.Multis[name]; ok {
+		return v.Help
+	}
+	return ""
+}
+
+// Type returns the declaration type of the named option, e.g. "bool",
+// or an empty string.
+func (o *Options) Type(name string) string {
+	if _, ok := o.Bools[name]; ok {
+		return "bool"
+	} else if _, ok := o.Choices[name]; ok {
+		return "choice"
+	} else if _, ok := o.Strings[name]; ok {
+		return "string"
+	} else if _, ok := o.Ints[name]; ok {
+		return "int"
+	} else if _, ok := o.Multis[name]; ok {
+		return "multi"
+	}
+	return ""
+}
+
+// Names returns the sorted names of all options in this scope.
+func (o *Options) Names() []string {
+	var names []string
+	for k := range o.Bools {
+		names = append(names, k)
+	}
+	for k := range o.Choices {
+		names = append(names, k)
+	}
+	for k := range o.Strings {
+		names = append(names, k)
+	}
+	for k := range o.Ints {
+		names = append(names, k)
+	}
+	for k := range o.Multis {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set parses value according to the named option's type and assigns it.
+// Multi-select values are separated by commas.
+func (o *Options) Set(name, value string) error {
+	if b, ok := o.Bools[name]; ok {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("option '%s': invalid bool value '%s'", name, value)
+		}
+		b.Value = v
+		return nil
+	} else if c, ok := o.Choices[name]; ok {
+		if !contains(c.Options, value) {
+			return fmt.Errorf("option '%s': invalid value '%s': expected one of %v", name, value, c.Options)
+		}
+		c.Active = value
+		c.UserSet = true
+		return nil
+	} else if s, ok := o.Strings[name]; ok {
+		if err := s.Set(value); err != nil {
+			return fmt.Errorf("option '%s': %v", name, err)
+		}
+		return nil
+	} else if i, ok := o.Ints[name]; ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("option '%s': invalid int value '%s'", name, value)
+		}
+		if err = i.Set(v); err != nil {
+			return fmt.Errorf("option '%s': %v", name, err)
+		}
+		return nil
+	} else if m, ok := o.Multis[name]; ok {
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if err := m.Set(values); err != nil {
+			return fmt.Errorf("option '%s': %v", name, err)
+		}
+		m.UserSet = true
+		return nil
+	}
+	return fmt.Errorf("option '%s': does not exist", name)
+}
+
+// Env returns the env representation of every option in this scope.
+func (o *Options) Env() map[string]string {
+	env := make(map[string]string)
+	for k, v := range o.Bools {
+		env[k] = strconv.FormatBool(v.Value)
+	}
+	for k, v := range o.Choices {
+		env[k] = v.Active
+	}
+	for k, v := range o.Strings {
+		env[k] = v.Value
+	}
+	for k, v := range o.Ints {
+		env[k] = strconv.Itoa(v.Value)
+	}
+	for k, v := range o.Multis {
+		env[k] = v.String()
+	}
+	return env
+}
+
+// MappedEnv returns the env variables mapped to the current values of
+// all options in this scope. For multi-select options the mappings of
+// all active values are merged; later values win. If options map the
+// same variable, the option sorting last by name wins.
+func (o *Options) MappedEnv() map[string]string {
+	env := make(map[string]string)
+	merge := func(ve ValueEnv, value string) {
+		for k, v := range ve[value] {
+			env[k] = v
+		}
+	}
+
+	names := make([]string, 0, len(o.ValueEnvs))
+	for name := range o.ValueEnvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ve := o.ValueEnvs[name]
+		if b, ok := o.Bools[name]; ok {
+			merge(ve, strconv.FormatBool(b.Value))
+		} else if c, ok := o.Choices[name]; ok {
+			merge(ve, c.Active)
+		} else if m, ok := o.Multis[name]; ok {
+			for _, v := range m.Active {
+				merge(ve, v)
+			}
+		}
+	}
+	return env
+}
+
 func (o *Options) Restore(p *Options) {
 	// Carry forward bool values when the option still exists in the new
 	// configuration.
@@ -90,6 +650,19 @@ func (o *Options) Restore(p *Options) {
 		}
 	}
 
+	// Carry forward string and int values if they are still valid with
+	// the new constraints.
+	for k, v := range o.Strings {
+		if pv, ok := p.Strings[k]; ok {
+			_ = v.Set(pv.Value)
+		}
+	}
+	for k, v := range o.Ints {
+		if pv, ok := p.Ints[k]; ok {
+			_ = v.Set(pv.Value)
+		}
+	}
+
 Loop:
 	for k, v := range o.Choices {
 		pv, ok := p.Choices[k]
@@ -109,4 +682,165 @@ Loop:
 			}
 		}
 	}
+
+	// Multi-select options keep the user's selection minus values that
+	// were removed from the list.
+	for k, v := range o.Multis {
+		pv, ok := p.Multis[k]
+		if !ok || !pv.UserSet {
+			continue
+		}
+		var active []string
+		for _, s := range pv.Active {
+			if contains(v.Options, s) {
+				active = append(active, s)
+			}
+		}
+		v.Active = active
+		v.UserSet = true
+	}
+}
+
+// decl is a long-form option declaration as decoded from YAML.
+type decl map[string]interface{}
+
+// allow returns an error if the declaration contains a key not in keys.
+func (d decl) allow(keys ...string) error {
+	for k := range d {
+		if !contains(keys, fmt.Sprintf("%v", k)) {
+			return fmt.Errorf("unknown field '%v'", k)
+		}
+	}
+	return nil
+}
+
+func (d decl) string(key string) (string, error) {
+	v, ok := d[key]
+	if !ok {
+		return "", fmt.Errorf("missing field '%s'", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field '%s': expected a string: %v", key, v)
+	}
+	return s, nil
+}
+
+func (d decl) optInt(key string) (*int, error) {
+	v, ok := d[key]
+	if !ok {
+		return nil, nil
+	}
+	i, ok := v.(int)
+	if !ok {
+		return nil, fmt.Errorf("field '%s': expected an integer: %v", key, v)
+	}
+	return &i, nil
+}
+
+func (d decl) list(key string) ([]string, error) {
+	v, ok := d[key]
+	if !ok {
+		return nil, fmt.Errorf("missing field '%s'", key)
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("field '%s': expected a list: %v", key, v)
+	}
+	return toStrings(l), nil
+}
+
+// values returns the non-empty value list under key. Each item is either
+// a plain value or a single-entry map of value to description:
+//
+//	options:
+//	    - cuda: build with NVIDIA GPU support
+//	    - cpu
+func (d decl) values(key string) (values []string, descs map[string]string, err error) {
+	v, ok := d[key]
+	if !ok {
+		return nil, nil, fmt.Errorf("missing field '%s'", key)
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("field '%s': expected a list: %v", key, v)
+	} else if len(l) == 0 {
+		return nil, nil, fmt.Errorf("field '%s': empty list", key)
+	}
+
+	descs = make(map[string]string)
+	for _, item := range l {
+		m, ok := toMap(item)
+		if !ok {
+			values = append(values, fmt.Sprintf("%v", item))
+			continue
+		} else if len(m) != 1 {
+			return nil, nil, fmt.Errorf("field '%s': expected a value or a single 'value: description' entry: %v", key, item)
+		}
+		for mk, mv := range m {
+			value := fmt.Sprintf("%v", mk)
+			values = append(values, value)
+			descs[value] = fmt.Sprintf("%v", mv)
+		}
+	}
+	return
+}
+
+// keys returns the keys of the map under key in declaration order. The
+// keys of maps without a recorded order are sorted.
+func (d decl) keys(key string) ([]string, error) {
+	v, ok := d[key]
+	if !ok {
+		return nil, fmt.Errorf("missing field '%s'", key)
+	}
+	m, ok := toMap(v)
+	if !ok {
+		return nil, fmt.Errorf("field '%s': expected a map: %v", key, v)
+	} else if len(m) == 0 {
+		return nil, fmt.Errorf("field '%s': empty map", key)
+	}
+	if om, ok := v.(OrderedMap); ok {
+		return append([]string(nil), om.Keys...), nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, fmt.Sprintf("%v", k))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// toMap returns v as a map with string keys. Nested YAML maps decode
+// with string or with arbitrary keys depending on the decoder.
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case OrderedMap:
+		return m.Values, true
+	case map[interface{}]interface{}:
+		s := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			s[fmt.Sprintf("%v", k)] = v
+		}
+		return s, true
+	}
+	return nil, false
+}
+
+func toStrings(l []interface{}) []string {
+	s := make([]string, len(l))
+	for i, v := range l {
+		s[i] = fmt.Sprintf("%v", v)
+	}
+	return s
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,376 @@
+/*
+ *  grml - A simple build automation tool written in Go
+ *  Copyright (C) 2017  Roland Singer <roland.singer[at]desertbit.com>
+ *
+ *  This program is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  This program is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package options
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parse(t *testing.T, src string) *Options {
+	t.Helper()
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(src), &raw); err != nil {
+		t.Fatal(err)
+	}
+	o := New()
+	if err := add(o, raw); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func add(o *Options, raw map[string]interface{}) error {
+	for name, v := range raw {
+		if err := o.Add(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAddTypes(t *testing.T) {
+	o := parse(t, `
+debug: false
+channel: [stable, beta]
+tag: latest
+jobs: 4
+version: {type: string, default: 1.0.0, pattern: '^[0-9.]+$'}
+level: {type: int, min: 1, max: 3}
+targets: {type: multi, options: [linux, windows, darwin], default: [linux, darwin], separator: ","}
+`)
+
+	env := o.Env()
+	want := map[string]string{
+		"debug":   "false",
+		"channel": "stable",
+		"tag":     "latest",
+		"jobs":    "4",
+		"version": "1.0.0",
+		"level":   "1",
+		"targets": "linux,darwin",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s: got %q, want %q", k, env[k], v)
+		}
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	cases := []string{
+		`a: {type: string, default: abc, pattern: '^[0-9]+$'}`,
+		`a: {type: int, default: 5, max: 3}`,
+		`a: {type: int, min: 3, max: 1}`,
+		`a: {type: multi, options: [x], default: [y]}`,
+		`a: {type: multi}`,
+		`a: {type: unknown}`,
+		`a: {type: string, foo: bar}`,
+		`a: []`,
+		`a: {type: bool, default: yes please}`,
+		`a: {type: choice, options: [x, y], default: z}`,
+		`a: {type: choice, options: [{x: 1, y: 2}]}`,
+		`a: {type: choice, options: [x], values: {y: {A: 1}}}`,
+		`a: {type: bool, values: {maybe: {A: 1}}}`,
+		`a: {type: string, values: {x: {A: 1}}}`,
+		`a: {values: {x: [1]}}`,
+	}
+	for _, src := range cases {
+		var raw map[string]interface{}
+		if err := yaml.Unmarshal([]byte(src), &raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := add(New(), raw); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	o := parse(t, `
+debug: false
+channel: [stable, beta]
+version: {type: string, pattern: '^[0-9.]+$'}
+tag: {type: string, default: rc}
+jobs: {type: int, min: 1, max: 8}
+targets: {type: multi, options: [linux, windows]}
+`)
+
+	valid := [][2]string{
+		{"debug", "true"},
+		{"channel", "beta"},
+		{"version", "2.0"},
+		{"tag", ""},
+		{"jobs", "8"},
+		{"targets", "windows, linux"},
+	}
+	for _, v := range valid {
+		if err := o.Set(v[0], v[1]); err != nil {
+			t.Errorf("%s=%s: %v", v[0], v[1], err)
+		}
+	}
+	if got := o.Env()["targets"]; got != "windows linux" {
+		t.Errorf("targets: got %q", got)
+	}
+	if got := o.Env()["tag"]; got != "" {
+		t.Errorf("tag: got %q", got)
+	}
+
+	invalid := [][2]string{
+		{"debug", "maybe"},
+		{"channel", "nightly"},
+		{"version", "v2"},
+		{"jobs", "9"},
+		{"jobs", "x"},
+		{"targets", "darwin"},
+		{"missing", "x"},
+	}
+	for _, v := range invalid {
+		if err := o.Set(v[0], v[1]); err == nil {
+			t.Errorf("%s=%s: expected error", v[0], v[1])
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	old := parse(t, `
+jobs: {type: int, max: 16}
+targets: {type: multi, options: [linux, windows, darwin]}
+`)
+	if err := old.Set("jobs", "12"); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Set("targets", "linux,darwin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new bounds reject the old int value and darwin was removed.
+	o := parse(t, `
+jobs: {type: int, max: 8}
+targets: {type: multi, options: [linux, windows]}
+`)
+	o.Restore(old)
+
+	if o.Ints["jobs"].Value != 0 {
+		t.Errorf("jobs: got %d, want 0", o.Ints["jobs"].Value)
+	}
+	if got := o.Multis["targets"].String(); got != "linux" {
+		t.Errorf("targets: got %q, want %q", got, "linux")
+	}
+}
+
+func TestAddLongForm(t *testing.T) {
+	o := parse(t, `
+debug: {type: bool, default: true, help: build with -N -l}
+runtime:
+    type: choice
+    help: compute runtime
+    default: cpu
+    options:
+        - cuda: NVIDIA GPU build
+        - cpu
+`)
+
+	if !o.Bools["debug"].Value {
+		t.Error("debug: expected true")
+	}
+	if got := o.Help("debug"); got != "build with -N -l" {
+		t.Errorf("debug help: got %q", got)
+	}
+
+	c := o.Choices["runtime"]
+	if c.Active != "cpu" {
+		t.Errorf("runtime: got %q, want %q", c.Active, "cpu")
+	}
+	if len(c.Options) != 2 || c.Options[0] != "cuda" || c.Options[1] != "cpu" {
+		t.Errorf("runtime options: got %v", c.Options)
+	}
+	if c.Descriptions["cuda"] != "NVIDIA GPU build" || c.Descriptions["cpu"] != "" {
+		t.Errorf("runtime descriptions: got %v", c.Descriptions)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	o := parse(t, `
+debug: false
+release: false
+runtime: [cpu, cuda]
+strip: {type: bool, conflicts: [debug]}
+symbols: {type: bool, requires: [debug, '!release']}
+arch: {type: string, only_if: runtime == cuda}
+gpu: {type: choice, options: [sm_80, sm_90], only_if: runtime == cuda}
+tag: {type: string}
+push: {type: bool, requires: [tag]}
+`)
+
+	check := func(want bool) {
+		t.Helper()
+		if err := o.Check(o.Env(), o.ActiveEnv()); (err == nil) != want {
+			t.Errorf("check: got %v", err)
+		}
+	}
+
+	check(true)
+
+	o.Bools["strip"].Value = true
+	check(true)
+	o.Bools["debug"].Value = true
+	check(false)
+	o.Bools["strip"].Value = false
+
+	o.Bools["symbols"].Value = true
+	check(true)
+	o.Bools["release"].Value = true
+	check(false)
+	o.Bools["release"].Value = false
+
+	o.Strings["arch"].Value = "sm_80"
+	check(false)
+	o.Choices["runtime"].Active = "cuda"
+	check(true)
+
+	// A choice with a default is only active while its condition holds,
+	// unless the user set it.
+	o.Choices["runtime"].Active = "cpu"
+	o.Strings["arch"].Value = ""
+	check(true)
+	if err := o.Set("gpu", "sm_90"); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+	o.Choices["runtime"].Active = "cuda"
+	check(true)
+
+	// A bare name holds while the option is active.
+	o.Bools["push"].Value = true
+	check(false)
+	o.Strings["tag"].Value = "v1"
+	check(true)
+}
+
+func TestCheckUnknown(t *testing.T) {
+	o := parse(t, `a: {type: bool, requires: [b]}`)
+	if err := o.Check(o.Env(), o.ActiveEnv()); err == nil {
+		t.Error("expected unknown option error")
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	cases := map[string]string{
+		"debug":             "debug",
+		"!tag":              "!tag",
+		" !debug ":          "!debug",
+		"runtime==cuda":     "runtime == cuda",
+		"runtime !=  cpu":   "runtime != cpu",
+		"channel == stable": "channel == stable",
+	}
+	for in, want := range cases {
+		c, err := ParseCondition(in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if c.String() != want {
+			t.Errorf("%q: got %q, want %q", in, c.String(), want)
+		}
+	}
+
+	for _, in := range []string{"", "!", "== cuda", "a b"} {
+		if _, err := ParseCondition(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestMappedEnv(t *testing.T) {
+	o := parse(t, `
+runtime:
+    default: cpu
+    values:
+        cuda: {GPU_FLAG: --cuda}
+        cpu: {GPU_FLAG: --cpu}
+debug:
+    type: bool
+    values:
+        true: {GOFLAGS: -race}
+targets:
+    type: multi
+    options: [linux, windows]
+    default: [linux, windows]
+    values:
+        linux: {LINUX: 1}
+        windows: {WINDOWS: 1}
+`)
+
+	c := o.Choices["runtime"]
+	if len(c.Options) != 2 || c.Options[0] != "cpu" || c.Active != "cpu" {
+		t.Fatalf("runtime: got %v, active %q", c.Options, c.Active)
+	}
+
+	env := o.MappedEnv()
+	want := map[string]string{"GPU_FLAG": "--cpu", "LINUX": "1", "WINDOWS": "1"}
+	if len(env) != len(want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s: got %q, want %q", k, env[k], v)
+		}
+	}
+
+	o.Choices["runtime"].Active = "cuda"
+	o.Bools["debug"].Value = true
+	env = o.MappedEnv()
+	if env["GPU_FLAG"] != "--cuda" || env["GOFLAGS"] != "-race" {
+		t.Errorf("got %v", env)
+	}
+}
+
+func TestMappedEnvOrder(t *testing.T) {
+	o := New()
+	err := o.Add("runtime", map[string]interface{}{
+		"values": OrderedMap{
+			Keys: []string{"cuda", "cpu"},
+			Values: map[string]interface{}{
+				"cuda": map[string]interface{}{"FLAG": "--cuda"},
+				"cpu":  map[string]interface{}{"FLAG": "--cpu"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := o.Choices["runtime"]
+	if len(c.Options) != 2 || c.Options[0] != "cuda" || c.Active != "cuda" {
+		t.Fatalf("runtime: got %v, active %q", c.Options, c.Active)
+	}
+
+	// Options mapping the same variable: the last by name wins.
+	err = o.Add("arch", map[string]interface{}{
+		"type":   "bool",
+		"values": map[string]interface{}{"false": map[string]interface{}{"FLAG": "--arch"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if env := o.MappedEnv(); env["FLAG"] != "--cuda" {
+			t.Fatalf("got %q, want --cuda", env["FLAG"])
+		}
+	}
+}
@@ -1,8 +1,6 @@
 #!/bin/bash
 
 # Sourced only when running commands inside this include's subtree.
-# Sees the per-include env (DESTBIN, RELEASE_NOTE, NOTES_FILE) plus root env.
+# Sees the per-include env (DESTBIN, RELEASE_NOTE, TAG_SUFFIX) plus root env.
 
-release_banner() {
-    echo "=== ${RELEASE_NOTE} ==="
-}
+RELEASE_TAG="v${VERSION}${TAG_SUFFIX}"
@@ -10,6 +10,9 @@ go_build() {
     if grml_option debug; then
         opts+=(-gcflags="all=-N -l")
     fi
+    if grml_option strip; then
+        opts+=(-ldflags="-s -w")
+    fi
 
-    go build "${opts[@]}" -o "${BINDIR}/${DESTBIN}"
+    go build -p "${jobs}" "${opts[@]}" -o "${BINDIR}/${DESTBIN}"
 }